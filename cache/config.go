@@ -0,0 +1,16 @@
+package cache
+
+// Config of the cache section.
+type Config struct {
+	Default string                  `config:"default" validate:"required"`
+	Prefix  string                  `config:"prefix"`
+	Stores  map[string]*StoreConfig `config:"stores" validate:"required"`
+}
+
+// StoreConfig of a single cache.stores.<name> entry.
+type StoreConfig struct {
+	Driver     string `config:"driver" validate:"required,oneof=file redis"`
+	Path       string `config:"path" default:"./"`
+	Connection string `config:"connection" default:"default"`
+	Prefix     string `config:"prefix"`
+}
@@ -1,12 +1,15 @@
 package cache
 
 import (
+	"context"
 	"fmt"
 	"sync"
+	"time"
 
 	"github.com/urionz/goofy"
 	"github.com/urionz/service/config"
 	"github.com/urionz/service/filesystem"
+	"github.com/urionz/service/health"
 	"github.com/urionz/service/redis"
 )
 
@@ -23,6 +26,8 @@ type Manager struct {
 
 var _ Factory = new(Manager)
 
+var _ health.Source = new(Manager)
+
 func NewManager(app goofy.IApplication, conf config.IConfig) *Manager {
 	manager := &Manager{
 		app:  app,
@@ -55,12 +60,14 @@ func (m *Manager) Driver(driver ...string) IRepository {
 
 // Resolve the given store.
 func (m *Manager) resolve(name string) (repo IRepository, err error) {
-	conf := m.getConfig(name)
-	if conf == nil {
+	if !m.conf.Exists(fmt.Sprintf("cache.stores.%s", name)) {
 		return nil, fmt.Errorf("cache store %s is not defined", name)
 	}
-	driver := conf.String("driver")
-	switch driver {
+	var conf *StoreConfig
+	if conf, err = m.getConfig(name); err != nil {
+		return nil, err
+	}
+	switch conf.Driver {
 	case DvrFile:
 		repo = m.createFileDriver(conf)
 		break
@@ -72,23 +79,22 @@ func (m *Manager) resolve(name string) (repo IRepository, err error) {
 }
 
 // Create an instance of the file cache driver.
-func (m *Manager) createFileDriver(conf config.IConfig) *Repository {
+func (m *Manager) createFileDriver(conf *StoreConfig) *Repository {
 	var files *filesystem.Filesystem
 	if err := m.app.Resolve(&files); err != nil {
 		return nil
 	}
-	return m.repository(NewFileStore(files, conf.String("path", "./")))
+	return m.repository(NewFileStore(files, conf.Path))
 }
 
 // Create an instance of the Redis cache driver.
-func (m *Manager) createRedisDriver(conf config.IConfig) *Repository {
+func (m *Manager) createRedisDriver(conf *StoreConfig) *Repository {
 	var rdm *redis.Manager
 	var err error
 	if err = m.app.Resolve(&rdm); err != nil {
 		return nil
 	}
-	connection := conf.String("connection", "default")
-	return m.repository(NewRedisStore(rdm, m.getPrefix(conf), connection))
+	return m.repository(NewRedisStore(rdm, m.getPrefix(conf), conf.Connection))
 }
 
 // Create a new cache repository with the given implementation.
@@ -96,15 +102,45 @@ func (m *Manager) repository(store Store) *Repository {
 	return NewRepository(store)
 }
 
-func (m *Manager) getConfig(name string) config.IConfig {
-	return m.conf.Object(fmt.Sprintf("cache.stores.%s", name))
+func (m *Manager) getConfig(name string) (*StoreConfig, error) {
+	conf := new(StoreConfig)
+	if err := m.conf.Decode(fmt.Sprintf("cache.stores.%s", name), conf); err != nil {
+		return nil, err
+	}
+	return conf, nil
 }
 
 func (m *Manager) getDefaultDriver() string {
 	return m.conf.String("cache.default")
 }
 
+// Get a check writing to each configured store, named cache.<store>.
+func (m *Manager) HealthChecks() map[string]health.CheckFunc {
+	var stores map[string]interface{}
+	if err := m.conf.Decode("cache.stores", &stores); err != nil {
+		return nil
+	}
+	checks := make(map[string]health.CheckFunc, len(stores))
+	for name := range stores {
+		name := name
+		checks["cache."+name] = func(context.Context) error {
+			store := m.Store(name)
+			if store == nil {
+				return fmt.Errorf("cache store %s can't be resolved", name)
+			}
+			if err := store.Put("health:check", time.Now().Unix(), time.Minute); err != nil {
+				return err
+			}
+			return store.Forget("health:check")
+		}
+	}
+	return checks
+}
+
 // Get the cache prefix.
-func (m *Manager) getPrefix(conf config.IConfig) string {
-	return conf.String("prefix", m.conf.String("cache.prefix"))
+func (m *Manager) getPrefix(conf *StoreConfig) string {
+	if conf.Prefix != "" {
+		return conf.Prefix
+	}
+	return m.conf.String("cache.prefix")
 }
@@ -7,16 +7,24 @@ import (
 	"github.com/goava/di"
 	"github.com/urionz/goofy"
 	"github.com/urionz/service/config"
+	"github.com/urionz/service/health"
 )
 
 func NewServiceProvider(app goofy.IApplication, conf config.IConfig) error {
-	if err := app.Provide(func() (*Manager, error) {
-		_, f, _, _ := runtime.Caller(0)
-		if err := conf.LoadExists(path.Join(path.Dir(f), "cache.toml")); err != nil {
-			return nil, err
+	_, f, _, _ := runtime.Caller(0)
+	if err := conf.LoadDefaults(path.Join(path.Dir(f), "cache.toml")); err != nil {
+		return err
+	}
+	// The defaults are read next to the sources, a binary shipped without
+	// them may have no cache section: it is only checked when present.
+	if conf.Exists("cache") {
+		if err := conf.RegisterSection("cache", new(Config)); err != nil {
+			return err
 		}
+	}
+	if err := app.Provide(func() (*Manager, error) {
 		return NewManager(app, conf), nil
-	}, di.As(new(Factory))); err != nil {
+	}, di.As(new(Factory), new(health.Source))); err != nil {
 		return err
 	}
 	return nil
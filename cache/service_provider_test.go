@@ -1,6 +1,8 @@
 package cache_test
 
 import (
+	"io/ioutil"
+	"os"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -21,3 +23,16 @@ func TestNewServiceProvider(t *testing.T) {
 		}).Run()
 	})
 }
+
+func TestServiceProviderDefaults(t *testing.T) {
+	workspace, err := ioutil.TempDir("", "cache")
+	require.NoError(t, err)
+	defer os.RemoveAll(workspace)
+
+	require.NotPanics(t, func() {
+		goofy.New(goofy.SetWorkspace(workspace)).AddServices(config.NewServiceProvider, cache.NewServiceProvider, func(conf config.IConfig, c cache.Factory) {
+			require.Equal(t, "file", conf.String("cache.default"))
+			require.Equal(t, "file", conf.String("cache.stores.file.driver"))
+		}).Run()
+	})
+}
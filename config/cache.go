@@ -0,0 +1,200 @@
+package config
+
+import (
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"time"
+
+	"github.com/urionz/color"
+)
+
+// Location of the compiled config snapshot, relative to the workspace.
+const CachedConfigFile = "bootstrap/cache/config.gob"
+
+func init() {
+	gob.Register(map[string]interface{}{})
+	gob.Register(map[interface{}]interface{}{})
+	gob.Register([]interface{}{})
+	gob.Register([]map[string]interface{}{})
+	gob.Register(time.Time{})
+}
+
+// cacheSnapshot holds the merged files before env overrides and decryption,
+// enc: values stay encrypted. ${VAR} references are already expanded though,
+// so the values they pull from the environment are on disk in plain text and
+// the file is only readable by its owner.
+type cacheSnapshot struct {
+	Sources []sourceStamp
+	Data    map[string]interface{}
+	Origins map[string]string
+	// Hash of the expansion of every ${VAR} reference found in the sources.
+	Env map[string]string
+}
+
+type sourceStamp struct {
+	Path    string
+	Exists  bool
+	ModTime int64
+	Size    int64
+}
+
+func stampSource(file string) sourceStamp {
+	stamp := sourceStamp{Path: file}
+	if info, err := os.Stat(file); err == nil {
+		stamp.Exists = true
+		stamp.ModTime = info.ModTime().UnixNano()
+		stamp.Size = info.Size()
+	}
+	return stamp
+}
+
+// Hash the current expansion of every ${VAR} reference of the files.
+func stampEnv(files []string) (map[string]string, error) {
+	env := make(map[string]string)
+	for _, file := range files {
+		src, err := ioutil.ReadFile(file)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, ref := range interpolatePattern.FindAll(src, -1) {
+			env[string(ref)] = hashEnv(string(ref))
+		}
+	}
+	return env, nil
+}
+
+func hashEnv(ref string) string {
+	sum := sha256.Sum256(interpolate([]byte(ref)))
+	return hex.EncodeToString(sum[:])
+}
+
+// Write every currently loaded file, merged, into a snapshot at file.
+func (c *Configure) WriteCache(file string) error {
+	c.mu.RLock()
+	sources := append(append([]string(nil), c.defaults...), c.files...)
+	c.mu.RUnlock()
+	merged, origins, err := mergeFiles(c.Name(), sources)
+	if err != nil {
+		return err
+	}
+	env, err := stampEnv(sources)
+	if err != nil {
+		return err
+	}
+	snapshot := &cacheSnapshot{
+		Data:    merged.Data(),
+		Origins: origins,
+		Env:     env,
+	}
+	for _, source := range sources {
+		snapshot.Sources = append(snapshot.Sources, stampSource(source))
+	}
+	if err = os.MkdirAll(path.Dir(file), os.ModePerm); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_TRUNC|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return gob.NewEncoder(f).Encode(snapshot)
+}
+
+// Use the snapshot at file, when present, in place of parsing the files it covers.
+func (c *Configure) LoadCache(file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+	snapshot := new(cacheSnapshot)
+	if err = gob.NewDecoder(f).Decode(snapshot); err != nil {
+		return fmt.Errorf("read config cache %s: %w", file, err)
+	}
+	c.mu.Lock()
+	c.cache = snapshot
+	c.mu.Unlock()
+	return nil
+}
+
+// Get a copy of the cached config when it was written from the very same
+// sources, in the same order, and neither them nor the environment values
+// they reference changed since. A stale cache is dropped.
+func (c *Configure) fromCache(sources []string) (map[string]interface{}, map[string]string) {
+	c.mu.RLock()
+	snapshot := c.cache
+	c.mu.RUnlock()
+	if snapshot == nil {
+		return nil, nil
+	}
+	if len(snapshot.Sources) != len(sources) {
+		return nil, nil
+	}
+	for index, stamp := range snapshot.Sources {
+		if stamp.Path != sources[index] {
+			return nil, nil
+		}
+	}
+	for _, stamp := range snapshot.Sources {
+		if stampSource(stamp.Path) != stamp {
+			c.dropCache(stamp.Path)
+			return nil, nil
+		}
+	}
+	for ref, hash := range snapshot.Env {
+		if hashEnv(ref) != hash {
+			c.dropCache(ref)
+			return nil, nil
+		}
+	}
+	return deepCopy(snapshot.Data).(map[string]interface{}), snapshot.Origins
+}
+
+func (c *Configure) dropCache(changed string) {
+	color.Warnln("config cache is stale, run config:cache to rebuild it:", changed, "changed")
+	c.mu.Lock()
+	c.cache = nil
+	c.mu.Unlock()
+}
+
+// Copy nested maps and slices so later merges never touch the cached data.
+func deepCopy(value interface{}) interface{} {
+	switch typed := value.(type) {
+	case map[string]interface{}:
+		copied := make(map[string]interface{}, len(typed))
+		for k, v := range typed {
+			copied[k] = deepCopy(v)
+		}
+		return copied
+	case map[interface{}]interface{}:
+		copied := make(map[interface{}]interface{}, len(typed))
+		for k, v := range typed {
+			copied[k] = deepCopy(v)
+		}
+		return copied
+	case []interface{}:
+		copied := make([]interface{}, len(typed))
+		for i, v := range typed {
+			copied[i] = deepCopy(v)
+		}
+		return copied
+	case []map[string]interface{}:
+		copied := make([]map[string]interface{}, len(typed))
+		for i, v := range typed {
+			copied[i] = deepCopy(v).(map[string]interface{})
+		}
+		return copied
+	}
+	return value
+}
@@ -0,0 +1,392 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/urionz/cobra"
+	"github.com/urionz/cobra/interact"
+	"github.com/urionz/cobra/show"
+	"github.com/urionz/color"
+	"github.com/urionz/goofy"
+	"github.com/urionz/goutil/fsutil"
+	"github.com/urionz/ini/dotenv"
+)
+
+const maskedValue = "******"
+
+var secretPattern = regexp.MustCompile(`(?i)(password|passwd|secret|token|credential|private_key|app_key|api_key)`)
+
+type ShowCommand struct {
+	format string
+}
+
+func (cmd *ShowCommand) Handle(app goofy.IApplication) *cobra.Command {
+	command := &cobra.Command{
+		Use:   "config:show [prefix]",
+		Short: "查看合并后的配置",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(c *cobra.Command, args []string) error {
+			var conf *Configure
+			if err := app.Resolve(&conf); err != nil {
+				return err
+			}
+			var data interface{} = conf.Data()
+			key := ""
+			if len(args) > 0 {
+				// A leaf is masked by its own name, the last segment of the key.
+				key = args[0][strings.LastIndex(args[0], ".")+1:]
+				data = conf.Get(args[0])
+				if data == nil {
+					err := fmt.Errorf("config key %s is not defined", args[0])
+					color.Errorln(err)
+					return err
+				}
+			}
+			if err := dump(c.OutOrStdout(), mask(key, data), cmd.format); err != nil {
+				color.Errorln(err)
+				return err
+			}
+			return nil
+		},
+	}
+
+	command.PersistentFlags().StringVarP(&cmd.format, "format", "f", "json", "输出格式 json|yaml|toml")
+
+	return command
+}
+
+type GetCommand struct {
+}
+
+func (cmd *GetCommand) Handle(app goofy.IApplication) *cobra.Command {
+	command := &cobra.Command{
+		Use:   "config:get <key>",
+		Short: "查看配置值及其来源",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(c *cobra.Command, args []string) error {
+			var conf *Configure
+			if err := app.Resolve(&conf); err != nil {
+				return err
+			}
+			key := args[0]
+			origins := conf.Origins(key)
+			if len(origins) == 0 {
+				err := fmt.Errorf("config key %s is not defined", key)
+				color.Errorln(err)
+				return err
+			}
+			keys := make([]string, 0, len(origins))
+			for leaf := range origins {
+				keys = append(keys, leaf)
+			}
+			sort.Strings(keys)
+			rows := []string{"Key\tValue\tSource"}
+			for _, leaf := range keys {
+				rows = append(rows, fmt.Sprintf("%s\t%v\t%s", leaf, mask(leaf, conf.Get(leaf)), origins[leaf]))
+			}
+			return show.TabWriter(c.OutOrStdout(), rows).Flush()
+		},
+	}
+
+	return command
+}
+
+type ValidateCommand struct {
+}
+
+func (cmd *ValidateCommand) Handle(app goofy.IApplication) *cobra.Command {
+	command := &cobra.Command{
+		Use:   "config:validate",
+		Short: "校验配置",
+		RunE: func(c *cobra.Command, args []string) error {
+			var conf *Configure
+			if err := app.Resolve(&conf); err != nil {
+				return err
+			}
+			if err := conf.ValidateSections(); err != nil {
+				color.Errorln(err)
+				return err
+			}
+			color.Infoln("Configuration is valid.")
+			return nil
+		},
+	}
+
+	return command
+}
+
+// Copy value replacing every secret looking leaf with a placeholder. The items
+// of a list are masked by the key of the list.
+func mask(key string, value interface{}) interface{} {
+	switch items := value.(type) {
+	case []interface{}:
+		masked := make([]interface{}, len(items))
+		for i, item := range items {
+			masked[i] = mask(key, item)
+		}
+		return masked
+	case []map[string]interface{}:
+		masked := make([]interface{}, len(items))
+		for i, item := range items {
+			masked[i] = mask(key, item)
+		}
+		return masked
+	}
+	if children, ok := toStringMap(value); ok {
+		masked := make(map[string]interface{}, len(children))
+		for child, nested := range children {
+			masked[child] = mask(child, nested)
+		}
+		return masked
+	}
+	if secretPattern.MatchString(key) {
+		return maskedValue
+	}
+	return value
+}
+
+func dump(out io.Writer, data interface{}, format string) error {
+	if format == "json" {
+		encoded, err := json.MarshalIndent(data, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(out, string(encoded))
+		return err
+	}
+	values, ok := data.(map[string]interface{})
+	if !ok {
+		_, err := fmt.Fprintln(out, data)
+		return err
+	}
+	conf := newConfig("dump")
+	conf.SetData(values)
+	_, err := conf.DumpTo(out, format)
+	return err
+}
+
+type EncryptCommand struct {
+}
+
+func (cmd *EncryptCommand) Handle(app goofy.IApplication) *cobra.Command {
+	command := &cobra.Command{
+		Use:   "config:encrypt <value>",
+		Short: "加密配置值",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(c *cobra.Command, args []string) error {
+			key, err := commandKey(app)
+			if err != nil {
+				color.Errorln(err)
+				return err
+			}
+			encrypted, err := Encrypt(key, []byte(args[0]))
+			if err != nil {
+				color.Errorln(err)
+				return err
+			}
+			fmt.Fprintln(c.OutOrStdout(), encrypted)
+			return nil
+		},
+	}
+
+	return command
+}
+
+type EnvEncryptCommand struct {
+	force bool
+}
+
+func (cmd *EnvEncryptCommand) Handle(app goofy.IApplication) *cobra.Command {
+	command := &cobra.Command{
+		Use:   "env:encrypt",
+		Short: "加密.env文件",
+		RunE: func(c *cobra.Command, args []string) error {
+			key, err := commandKey(app)
+			if err != nil {
+				color.Errorln(err)
+				return err
+			}
+			plain, err := ioutil.ReadFile(path.Join(app.Workspace(), ".env"))
+			if err != nil {
+				color.Errorln(err)
+				return err
+			}
+			encrypted, err := Encrypt(key, plain)
+			if err != nil {
+				color.Errorln(err)
+				return err
+			}
+			written, err := writeEnvFile(path.Join(app.Workspace(), EncryptedEnvFile), []byte(encrypted+"\n"), cmd.force)
+			if err != nil {
+				color.Errorln(err)
+				return err
+			}
+			if !written {
+				return nil
+			}
+			color.Infoln("Environment file encrypted to", EncryptedEnvFile)
+			return nil
+		},
+	}
+
+	command.PersistentFlags().BoolVarP(&cmd.force, "force", "f", false, "覆盖已存在的文件")
+
+	return command
+}
+
+type EnvDecryptCommand struct {
+	force bool
+}
+
+func (cmd *EnvDecryptCommand) Handle(app goofy.IApplication) *cobra.Command {
+	command := &cobra.Command{
+		Use:   "env:decrypt",
+		Short: "解密.env.encrypted文件",
+		RunE: func(c *cobra.Command, args []string) error {
+			key, err := commandKey(app)
+			if err != nil {
+				color.Errorln(err)
+				return err
+			}
+			encrypted, err := ioutil.ReadFile(path.Join(app.Workspace(), EncryptedEnvFile))
+			if err != nil {
+				color.Errorln(err)
+				return err
+			}
+			plain, err := Decrypt(key, strings.TrimSpace(string(encrypted)))
+			if err != nil {
+				color.Errorln(err)
+				return err
+			}
+			written, err := writeEnvFile(path.Join(app.Workspace(), ".env"), plain, cmd.force)
+			if err != nil {
+				color.Errorln(err)
+				return err
+			}
+			if !written {
+				return nil
+			}
+			color.Infoln("Environment file decrypted to .env")
+			return nil
+		},
+	}
+
+	command.PersistentFlags().BoolVarP(&cmd.force, "force", "f", false, "覆盖已存在的文件")
+
+	return command
+}
+
+// Resolve the key the same way the service provider does.
+func commandKey(app goofy.IApplication) ([]byte, error) {
+	dotenv.LoadExists(app.Workspace(), ".env")
+	key, err := LoadKey(app.Workspace())
+	if err != nil {
+		return nil, err
+	}
+	if key == nil {
+		return nil, errNoKey
+	}
+	return key, nil
+}
+
+// Write content to file, asking before an existing file is replaced.
+func writeEnvFile(file string, content []byte, force bool) (bool, error) {
+	if !force && fsutil.FileExists(file) {
+		color.Infoln(path.Base(file), "已存在，是否覆盖？")
+		if !interact.AnswerIsYes(false) {
+			return false, nil
+		}
+	}
+	return true, ioutil.WriteFile(file, content, 0600)
+}
+
+type CacheCommand struct {
+}
+
+func (cmd *CacheCommand) Handle(app goofy.IApplication) *cobra.Command {
+	command := &cobra.Command{
+		Use:   "config:cache",
+		Short: "生成配置缓存",
+		RunE: func(c *cobra.Command, args []string) error {
+			var conf *Configure
+			if err := app.Resolve(&conf); err != nil {
+				return err
+			}
+			if err := conf.WriteCache(path.Join(app.Workspace(), CachedConfigFile)); err != nil {
+				color.Errorln(err)
+				return err
+			}
+			color.Infoln("Configuration cached successfully.")
+			return nil
+		},
+	}
+
+	return command
+}
+
+type ClearCommand struct {
+}
+
+func (cmd *ClearCommand) Handle(app goofy.IApplication) *cobra.Command {
+	command := &cobra.Command{
+		Use:   "config:clear",
+		Short: "清除配置缓存",
+		RunE: func(c *cobra.Command, args []string) error {
+			if err := os.Remove(path.Join(app.Workspace(), CachedConfigFile)); err != nil && !os.IsNotExist(err) {
+				color.Errorln(err)
+				return err
+			}
+			color.Infoln("Configuration cache cleared.")
+			return nil
+		},
+	}
+
+	return command
+}
+
+type PublishCommand struct {
+	tags  []string
+	force bool
+}
+
+func (cmd *PublishCommand) Handle(app goofy.IApplication) *cobra.Command {
+	command := &cobra.Command{
+		Use:   "vendor:publish",
+		Short: "发布扩展包资源",
+		RunE: func(c *cobra.Command, args []string) error {
+			var conf *Configure
+			if err := app.Resolve(&conf); err != nil {
+				return err
+			}
+			tags := cmd.tags
+			if len(tags) == 0 {
+				tags = conf.PublishTags()
+			}
+			for _, tag := range tags {
+				published, err := conf.Publish(app.Workspace(), tag, cmd.force)
+				for _, target := range published {
+					color.Infoln("Published:", target)
+				}
+				if err != nil {
+					color.Errorln(err)
+					return err
+				}
+			}
+			color.Infoln("Publishing complete.")
+			return nil
+		},
+	}
+
+	command.PersistentFlags().StringSliceVarP(&cmd.tags, "tag", "t", nil, "发布的资源标签")
+	command.PersistentFlags().BoolVarP(&cmd.force, "force", "f", false, "覆盖已存在的文件")
+
+	return command
+}
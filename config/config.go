@@ -1,7 +1,15 @@
 package config
 
 import (
+	"path"
+	"sync"
+
 	"github.com/urionz/config"
+	"github.com/urionz/config/hcl"
+	"github.com/urionz/config/ini"
+	"github.com/urionz/config/json"
+	"github.com/urionz/config/toml"
+	"github.com/urionz/config/yaml"
 	"github.com/urionz/ini/dotenv"
 )
 
@@ -18,14 +26,64 @@ type IConfig interface {
 	Env(key string, defVal interface{}) interface{}
 	Exists(key string, findByPath ...bool) bool
 	LoadExists(...string) error
+	LoadDefaults(...string) error
 	Object(key string, findByPath ...bool) IConfig
 	Data() map[string]interface{}
+	Reload() error
+	OnChange(keyPrefix string, handler ChangeHandler)
+	Decode(key string, ptr interface{}) error
+	RegisterSection(key string, schema interface{}) error
+	ValidateSections() error
+	Publishes(tag string, paths map[string]string)
 }
 
 var _ IConfig = new(Configure)
 
 type Configure struct {
-	*config.Config
+	// Only read and written under mu, reloads swap its data.
+	store     *config.Config
+	mu        sync.RWMutex
+	base      map[string]interface{}
+	defaults  []string
+	files     []string
+	sets      []setValue
+	sections  []*section
+	listeners []*listener
+	origins   map[string]string
+	key       []byte
+	cache     *cacheSnapshot
+	publishes map[string]map[string]string
+}
+
+type setValue struct {
+	key       string
+	val       interface{}
+	setByPath []bool
+}
+
+// Create a config instance with all supported file drivers registered.
+func newConfig(name string) *config.Config {
+	conf := config.New(name)
+	conf.AddDriver(yaml.Driver)
+	conf.AddDriver(json.Driver)
+	conf.AddDriver(ini.Driver)
+	conf.AddDriver(hcl.Driver)
+	conf.AddDriver(toml.Driver)
+	return conf
+}
+
+// Wrap a copy of the given data into a read only snapshot.
+func snapshot(name string, data map[string]interface{}) *Configure {
+	conf := config.New(name)
+	conf.SetData(data)
+	return &Configure{
+		store: conf,
+	}
+}
+
+// Get the name given through WithName.
+func (c *Configure) Name() string {
+	return c.store.Name()
 }
 
 func (*Configure) Env(key string, defVal interface{}) interface{} {
@@ -40,20 +98,134 @@ func (*Configure) Env(key string, defVal interface{}) interface{} {
 	return dotenv.Get(key, defVal.(string))
 }
 
+// Load the given files on top of the already loaded ones, missing files are skipped.
+func (c *Configure) LoadExists(files ...string) error {
+	c.mu.RLock()
+	loaded := append(append([]string(nil), c.files...), files...)
+	defaults := c.defaults
+	c.mu.RUnlock()
+	return c.rebuild(defaults, loaded)
+}
+
+// Load package default files underneath every other config source. They are
+// also registered for vendor:publish --tag=config, which copies them into
+// config.d so they can be edited in the workspace.
+func (c *Configure) LoadDefaults(files ...string) error {
+	for _, file := range files {
+		c.Publishes(PublishTagConfig, map[string]string{
+			file: path.Join(ConfigDir, path.Base(file)),
+		})
+	}
+	c.mu.RLock()
+	defaults := append(append([]string(nil), c.defaults...), files...)
+	loaded := c.files
+	c.mu.RUnlock()
+	return c.rebuild(defaults, loaded)
+}
+
 func (c *Configure) Object(key string, findByPath ...bool) IConfig {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
 	conf := config.New(key)
-	val, ok := c.GetValue(key, findByPath...)
+	val, ok := c.store.GetValue(key, findByPath...)
 	if !ok {
 		conf.SetData(make(map[string]interface{}))
 	} else {
 		conf.SetData(val.(map[string]interface{}))
 	}
 	return &Configure{
-		Config: conf,
+		store: conf,
+	}
+}
+
+func (c *Configure) Get(key string, findByPath ...bool) interface{} {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.store.Get(key, findByPath...)
+}
+
+// Set a value above every other source, it is kept across reloads.
+func (c *Configure) Set(key string, val interface{}, setByPath ...bool) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.store.Set(key, val, setByPath...); err != nil {
+		return err
 	}
+	c.sets = append(c.sets, setValue{key: key, val: val, setByPath: setByPath})
+	if c.origins == nil {
+		c.origins = make(map[string]string)
+	}
+	trackOrigins(c.origins, key, val, "runtime")
+	return nil
 }
 
-var serve *Configure
+func (c *Configure) String(key string, defVal ...string) string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.store.String(key, defVal...)
+}
+
+func (c *Configure) Strings(key string) []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.store.Strings(key)
+}
+
+func (c *Configure) Int(key string, defVal ...int) int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.store.Int(key, defVal...)
+}
+
+func (c *Configure) Ints(key string) []int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.store.Ints(key)
+}
+
+func (c *Configure) Int64(key string, defVal ...int64) int64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.store.Int64(key, defVal...)
+}
+
+func (c *Configure) Uint(key string, defVal ...uint) uint {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.store.Uint(key, defVal...)
+}
+
+func (c *Configure) Bool(key string, defVal ...bool) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.store.Bool(key, defVal...)
+}
+
+func (c *Configure) Exists(key string, findByPath ...bool) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.store.Exists(key, findByPath...)
+}
+
+func (c *Configure) Data() map[string]interface{} {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.store.Data()
+}
+
+var serve = &Configure{
+	store: newConfig("default"),
+}
+
+// Bind conf to the package level facade functions.
+func SetDefault(conf *Configure) {
+	serve = conf
+}
+
+// Get the instance behind the package level facade functions.
+func Default() *Configure {
+	return serve
+}
 
 func LoadExists(files ...string) error {
 	return serve.LoadExists(files...)
@@ -102,3 +274,7 @@ func Uint(key string, defVal ...uint) uint {
 func Bool(key string, defVal ...bool) bool {
 	return serve.Bool(key, defVal...)
 }
+
+func OnChange(keyPrefix string, handler ChangeHandler) {
+	serve.OnChange(keyPrefix, handler)
+}
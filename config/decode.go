@@ -0,0 +1,225 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/mitchellh/mapstructure"
+)
+
+const (
+	// struct tag holding the config key of a field, defaults to the field name
+	keyTag = "config"
+	// struct tag holding the value used when the key is missing
+	defaultTag = "default"
+)
+
+// Decode the value under key into ptr. Missing keys take the value of their
+// `default` tag, strings are converted to durations and comma separated
+// slices, and the result is checked against the `validate` tags.
+//
+//	type Conn struct {
+//		Host    string        `config:"host" default:"localhost" validate:"required"`
+//		Port    int           `config:"port" default:"3306" validate:"min=1,max=65535"`
+//		Timeout time.Duration `config:"timeout" default:"5s"`
+//	}
+func (c *Configure) Decode(key string, ptr interface{}) error {
+	if key == "" {
+		return decode(key, c.Data(), ptr)
+	}
+	return decode(key, c.Get(key), ptr)
+}
+
+func decode(key string, data interface{}, ptr interface{}) error {
+	value := reflect.ValueOf(ptr)
+	if value.Kind() != reflect.Ptr || value.IsNil() {
+		return fmt.Errorf("config: decode %s requires a non-nil pointer, got %T", key, ptr)
+	}
+	decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		Result:           ptr,
+		TagName:          keyTag,
+		WeaklyTypedInput: true,
+		DecodeHook: mapstructure.ComposeDecodeHookFunc(
+			mapstructure.StringToTimeDurationHookFunc(),
+			mapstructure.StringToSliceHookFunc(","),
+		),
+	})
+	if err != nil {
+		return err
+	}
+	data = withDefaults(value.Type(), data)
+	if err = decoder.Decode(data); err != nil {
+		merr, ok := err.(*mapstructure.Error)
+		if !ok {
+			return err
+		}
+		errs := make(ValidationErrors, len(merr.Errors))
+		for index, message := range merr.Errors {
+			errs[index] = &FieldError{Path: key, Rule: "decode", Message: message}
+		}
+		return errs
+	}
+	return validate(key, value, data)
+}
+
+// Fill missing keys of data with the default tags declared by t. The given
+// data is never modified, maps are copied on the way down.
+func withDefaults(t reflect.Type, data interface{}) interface{} {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		src, ok := toStringMap(data)
+		if !ok {
+			if data != nil {
+				return data
+			}
+			src = make(map[string]interface{})
+		}
+		dst := make(map[string]interface{}, len(src))
+		for k, v := range src {
+			dst[k] = v
+		}
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			name, ok := fieldKey(field)
+			if !ok {
+				continue
+			}
+			if key, value, exists := lookupKey(dst, name); exists {
+				dst[key] = withDefaults(field.Type, value)
+			} else if def, has := field.Tag.Lookup(defaultTag); has {
+				dst[name] = def
+			} else if isStruct(field.Type) {
+				dst[name] = withDefaults(field.Type, nil)
+			}
+		}
+		return dst
+	case reflect.Map:
+		src, ok := toStringMap(data)
+		if !ok {
+			return data
+		}
+		dst := make(map[string]interface{}, len(src))
+		for k, v := range src {
+			dst[k] = withDefaults(t.Elem(), v)
+		}
+		return dst
+	case reflect.Slice, reflect.Array:
+		items, ok := data.([]interface{})
+		if !ok {
+			return data
+		}
+		dst := make([]interface{}, len(items))
+		for index, item := range items {
+			dst[index] = withDefaults(t.Elem(), item)
+		}
+		return dst
+	}
+	return data
+}
+
+// Get the config key of a struct field, false when the field is skipped.
+func fieldKey(field reflect.StructField) (string, bool) {
+	if field.PkgPath != "" {
+		return "", false
+	}
+	name := strings.Split(field.Tag.Get(keyTag), ",")[0]
+	if name == "-" {
+		return "", false
+	}
+	if name == "" {
+		name = field.Name
+	}
+	return name, true
+}
+
+// Find a key the way mapstructure matches field names, ignoring case.
+func lookupKey(data map[string]interface{}, name string) (string, interface{}, bool) {
+	if value, ok := data[name]; ok {
+		return name, value, true
+	}
+	for key, value := range data {
+		if strings.EqualFold(key, name) {
+			return key, value, true
+		}
+	}
+	return "", nil, false
+}
+
+func toStringMap(data interface{}) (map[string]interface{}, bool) {
+	switch typed := data.(type) {
+	case map[string]interface{}:
+		return typed, true
+	case map[interface{}]interface{}:
+		converted := make(map[string]interface{}, len(typed))
+		for k, v := range typed {
+			converted[fmt.Sprint(k)] = v
+		}
+		return converted, true
+	}
+	return nil, false
+}
+
+func isStruct(t reflect.Type) bool {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t.Kind() == reflect.Struct
+}
+
+type section struct {
+	key    string
+	schema reflect.Type
+}
+
+// Register the struct a config section must decode into. The section is
+// validated right away, and again on every reload so an invalid change is
+// rejected before it replaces the running config.
+func (c *Configure) RegisterSection(key string, schema interface{}) error {
+	s := &section{
+		key:    key,
+		schema: reflect.TypeOf(schema),
+	}
+	for s.schema.Kind() == reflect.Ptr {
+		s.schema = s.schema.Elem()
+	}
+	c.mu.Lock()
+	c.sections = append(c.sections, s)
+	c.mu.Unlock()
+	return s.validate(c)
+}
+
+// Validate every registered section against the current config.
+func (c *Configure) ValidateSections() error {
+	c.mu.RLock()
+	sections := c.sections
+	c.mu.RUnlock()
+	return validateSections(c, sections)
+}
+
+func (s *section) validate(conf IConfig) error {
+	return conf.Decode(s.key, reflect.New(s.schema).Interface())
+}
+
+// Validate sections and merge every failure into a single error.
+func validateSections(conf IConfig, sections []*section) error {
+	var errs ValidationErrors
+	for _, s := range sections {
+		err := s.validate(conf)
+		if err == nil {
+			continue
+		}
+		fields, ok := err.(ValidationErrors)
+		if !ok {
+			return err
+		}
+		errs = append(errs, fields...)
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
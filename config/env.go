@@ -0,0 +1,58 @@
+package config
+
+import (
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/urionz/config"
+	"github.com/urionz/ini/dotenv"
+)
+
+// Prefix of environment variables overriding config keys, path segments are
+// separated by a double underscore: APP__DATABASE__CONNS__MYSQL__HOST
+// overrides database.conns.mysql.host.
+const EnvOverridePrefix = "APP__"
+
+var interpolatePattern = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+
+// Replace ${VAR} and ${VAR:-default} references with environment values.
+// Unset variables without a default expand to an empty string.
+func interpolate(src []byte) []byte {
+	return interpolatePattern.ReplaceAllFunc(src, func(ref []byte) []byte {
+		match := interpolatePattern.FindSubmatch(ref)
+		return []byte(dotenv.Get(string(match[1]), string(match[3])))
+	})
+}
+
+// Convert an override variable name to its config key.
+func envOverrideKey(name string) (string, bool) {
+	if !strings.HasPrefix(name, EnvOverridePrefix) {
+		return "", false
+	}
+	segments := strings.Split(strings.TrimPrefix(name, EnvOverridePrefix), "__")
+	for index, segment := range segments {
+		if segment == "" {
+			return "", false
+		}
+		segments[index] = strings.ToLower(segment)
+	}
+	return strings.Join(segments, "."), true
+}
+
+// Apply every APP__ prefixed environment variable on top of the loaded files.
+func applyEnvOverrides(conf *config.Config, origins map[string]string) error {
+	for _, env := range os.Environ() {
+		pair := strings.SplitN(env, "=", 2)
+		if len(pair) != 2 {
+			continue
+		}
+		if key, ok := envOverrideKey(pair[0]); ok {
+			if err := conf.Set(key, pair[1]); err != nil {
+				return err
+			}
+			trackOrigins(origins, key, pair[1], pair[0])
+		}
+	}
+	return nil
+}
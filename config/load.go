@@ -0,0 +1,193 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"github.com/urionz/config"
+)
+
+// Sources are merged in the following order, later ones win:
+//
+//  0. in memory data given through WithData
+//  1. package defaults registered through LoadDefaults
+//  2. config.d files, published package defaults among them
+//  3. config.toml
+//  4. config.<profile>.toml (or APP_CONF) and other files passed to LoadExists
+//  5. APP__ prefixed environment variables
+//  6. values assigned at runtime through Set
+//
+// ${VAR} and ${VAR:-default} references are expanded in every file before it
+// is parsed, and enc: values are decrypted once everything is merged. The
+// files are taken from the config cache instead when it covers all of them.
+func (c *Configure) build(defaults, files []string) (*config.Config, map[string]string, error) {
+	sources := append(append([]string(nil), defaults...), files...)
+	conf := newConfig(c.Name())
+	origins := make(map[string]string)
+	if c.base != nil {
+		base := normalize(deepCopy(c.base))
+		if err := conf.LoadData(base); err != nil {
+			return nil, nil, err
+		}
+		trackOrigins(origins, "", base, "memory")
+	}
+	if cached, cachedOrigins := c.fromCache(sources); cached != nil {
+		if err := conf.LoadData(cached); err != nil {
+			return nil, nil, err
+		}
+		for key, origin := range cachedOrigins {
+			origins[key] = origin
+		}
+	} else {
+		for _, file := range sources {
+			if err := loadFile(conf, file, origins); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+	if err := applyEnvOverrides(conf, origins); err != nil {
+		return nil, nil, err
+	}
+	c.mu.RLock()
+	sets := c.sets
+	c.mu.RUnlock()
+	for _, set := range sets {
+		if err := conf.Set(set.key, set.val, set.setByPath...); err != nil {
+			return nil, nil, err
+		}
+		trackOrigins(origins, set.key, set.val, "runtime")
+	}
+	c.mu.RLock()
+	key := c.key
+	c.mu.RUnlock()
+	data, err := decryptValues(key, "", conf.Data())
+	if err != nil {
+		return nil, nil, err
+	}
+	conf.SetData(data.(map[string]interface{}))
+	return conf, origins, nil
+}
+
+// Re-read every config source and swap the result in. The current data is
+// kept untouched when any source fails to load.
+func (c *Configure) Reload() error {
+	c.mu.RLock()
+	defaults, files := c.defaults, c.files
+	c.mu.RUnlock()
+	return c.rebuild(defaults, files)
+}
+
+func (c *Configure) rebuild(defaults, files []string) error {
+	next, origins, err := c.build(defaults, files)
+	if err != nil {
+		return err
+	}
+	c.mu.RLock()
+	sections := c.sections
+	c.mu.RUnlock()
+	if err = validateSections(snapshot(c.Name(), next.Data()), sections); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	prev := snapshot(c.Name(), c.store.Data())
+	c.defaults, c.files, c.origins = defaults, files, origins
+	c.store.SetData(next.Data())
+	c.store.ClearCaches()
+	listeners := append([]*listener(nil), c.listeners...)
+	c.mu.Unlock()
+
+	current := snapshot(c.Name(), next.Data())
+	for _, l := range listeners {
+		if !reflect.DeepEqual(prev.lookup(l.prefix), current.lookup(l.prefix)) {
+			l.handler(prev, current)
+		}
+	}
+	return nil
+}
+
+// Get the source of every leaf key equal to or nested under prefix. A source
+// is the file path, the env variable or "runtime" for values set through Set.
+func (c *Configure) Origins(prefix string) map[string]string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	origins := make(map[string]string)
+	for key, origin := range c.origins {
+		if prefix == "" || key == prefix || strings.HasPrefix(key, prefix+".") {
+			origins[key] = origin
+		}
+	}
+	return origins
+}
+
+// Merge the given files in order, without env overrides or decryption.
+func mergeFiles(name string, files []string) (*config.Config, map[string]string, error) {
+	conf := newConfig(name)
+	origins := make(map[string]string)
+	for _, file := range files {
+		if err := loadFile(conf, file, origins); err != nil {
+			return nil, nil, err
+		}
+	}
+	return conf, origins, nil
+}
+
+// Read, interpolate and merge a single file, missing files are skipped.
+func loadFile(conf *config.Config, file string, origins map[string]string) error {
+	src, err := ioutil.ReadFile(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	format := strings.Trim(filepath.Ext(file), ".")
+	src = interpolate(src)
+	parsed := newConfig(file)
+	if err = parsed.LoadSources(format, src); err != nil {
+		return err
+	}
+	data := normalize(parsed.Data())
+	trackOrigins(origins, "", data, file)
+	return conf.LoadData(data)
+}
+
+// Convert yaml's interface keyed maps so files of different formats merge.
+func normalize(value interface{}) interface{} {
+	if children, ok := toStringMap(value); ok {
+		normalized := make(map[string]interface{}, len(children))
+		for key, child := range children {
+			normalized[key] = normalize(child)
+		}
+		return normalized
+	}
+	if items, ok := value.([]interface{}); ok {
+		normalized := make([]interface{}, len(items))
+		for index, item := range items {
+			normalized[index] = normalize(item)
+		}
+		return normalized
+	}
+	return value
+}
+
+// Record origin as the source of every leaf key found in value.
+func trackOrigins(origins map[string]string, key string, value interface{}, origin string) {
+	children, ok := toStringMap(value)
+	if !ok {
+		for tracked := range origins {
+			if strings.HasPrefix(tracked, key+".") {
+				delete(origins, tracked)
+			}
+		}
+		origins[key] = origin
+		return
+	}
+	delete(origins, key)
+	for child, nested := range children {
+		trackOrigins(origins, joinPath(key, child), nested, origin)
+	}
+}
@@ -0,0 +1,177 @@
+package config
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/urionz/color"
+	"github.com/urionz/ini/dotenv"
+)
+
+type options struct {
+	name      string
+	workspace string
+	data      map[string]interface{}
+	defaults  []string
+	files     []string
+	key       []byte
+	watch     time.Duration
+	facade    bool
+}
+
+// Option configures a Configure built by NewConfigure.
+type Option func(*options)
+
+// Name the config instance, defaults to "goofy".
+func WithName(name string) Option {
+	return func(opts *options) {
+		opts.name = name
+	}
+}
+
+// Load the workspace the way the service provider does: .env, the encryption
+// key, .env.encrypted, the config cache and the workspace config files.
+func WithWorkspace(workspace string) Option {
+	return func(opts *options) {
+		opts.workspace = workspace
+	}
+}
+
+// Use data as the lowest config layer, handy to build configs in memory.
+func WithData(data map[string]interface{}) Option {
+	return func(opts *options) {
+		opts.data = data
+	}
+}
+
+// Load package default files underneath the config files.
+func WithDefaults(files ...string) Option {
+	return func(opts *options) {
+		opts.defaults = append(opts.defaults, files...)
+	}
+}
+
+// Load files on top of the workspace files.
+func WithFiles(files ...string) Option {
+	return func(opts *options) {
+		opts.files = append(opts.files, files...)
+	}
+}
+
+// Decrypt enc: values with key instead of the one found in the workspace.
+func WithKey(key []byte) Option {
+	return func(opts *options) {
+		opts.key = key
+	}
+}
+
+// Watch the loaded files and reload on change every interval.
+func WithWatch(interval time.Duration) Option {
+	return func(opts *options) {
+		opts.watch = interval
+	}
+}
+
+// Bind the instance to the package level facade: config.Get, config.String...
+func AsDefault() Option {
+	return func(opts *options) {
+		opts.facade = true
+	}
+}
+
+// Create an isolated config instance, usable without the container.
+//
+//	conf, err := config.NewConfigure(config.WithData(map[string]interface{}{
+//		"database": map[string]interface{}{"default": "mysql"},
+//	}))
+func NewConfigure(opts ...Option) (*Configure, error) {
+	o := &options{
+		name: "goofy",
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	conf := &Configure{
+		store: newConfig(o.name),
+		base:  o.data,
+		key:   o.key,
+	}
+
+	files := o.files
+	if o.workspace != "" {
+		dotenv.LoadExists(o.workspace, ".env")
+
+		if conf.key == nil {
+			key, err := LoadKey(o.workspace)
+			if err != nil {
+				return nil, err
+			}
+			conf.key = key
+		}
+
+		if err := loadEncryptedEnv(o.workspace, conf.key); err != nil {
+			return nil, err
+		}
+
+		if err := conf.LoadCache(path.Join(o.workspace, CachedConfigFile)); err != nil {
+			color.Warnln(err)
+		}
+
+		workspace, err := workspaceFiles(o.workspace)
+		if err != nil {
+			return nil, err
+		}
+		files = append(workspace, files...)
+	}
+
+	if err := conf.rebuild(o.defaults, files); err != nil {
+		return nil, err
+	}
+
+	if o.watch > 0 {
+		conf.Watch(o.watch)
+	}
+
+	if o.facade {
+		SetDefault(conf)
+	}
+
+	return conf, nil
+}
+
+// List the workspace config files in merge order: the config.d files in
+// lexical order, config.toml, then config.<profile>.toml for every profile of
+// APP_PROFILES (eg: prod,eu-west,canary), defaulting to APP_ENV. APP_CONF
+// replaces the profile files with a single file. Published package defaults
+// land in config.d, so config.toml keeps the last word over them.
+func workspaceFiles(workspace string) ([]string, error) {
+	files, err := configDirFiles(workspace)
+	if err != nil {
+		return nil, err
+	}
+	files = append(files, path.Join(workspace, "config.toml"))
+
+	if conf := dotenv.Get("APP_CONF"); conf != "" {
+		return append(files, path.Join(workspace, conf)), nil
+	}
+
+	for _, profile := range Profiles() {
+		files = append(files, path.Join(workspace, fmt.Sprintf("config.%s.toml", profile)))
+	}
+	return files, nil
+}
+
+// Get the active profiles in merge order: APP_PROFILES (eg: prod,eu-west,canary),
+// defaulting to APP_ENV, then dev.
+func Profiles() []string {
+	var profiles []string
+	for _, profile := range strings.Split(dotenv.Get("APP_PROFILES", dotenv.Get("APP_ENV", "dev")), ",") {
+		if profile = strings.TrimSpace(profile); profile != "" {
+			profiles = append(profiles, profile)
+		}
+	}
+	return profiles
+}
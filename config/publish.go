@@ -0,0 +1,99 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+)
+
+// Tag grouping the package default config files.
+const PublishTagConfig = "config"
+
+// Directory, relative to the workspace, whose files are merged in lexical order.
+const ConfigDir = "config.d"
+
+// Register files copied into the workspace by vendor:publish. paths maps a
+// source path to its target, relative to the workspace.
+func (c *Configure) Publishes(tag string, paths map[string]string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.publishes == nil {
+		c.publishes = make(map[string]map[string]string)
+	}
+	if c.publishes[tag] == nil {
+		c.publishes[tag] = make(map[string]string)
+	}
+	for source, target := range paths {
+		c.publishes[tag][source] = target
+	}
+}
+
+// Get the registered tags in lexical order.
+func (c *Configure) PublishTags() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	tags := make([]string, 0, len(c.publishes))
+	for tag := range c.publishes {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+	return tags
+}
+
+// Copy the files registered under tag into the workspace, existing targets are
+// skipped unless force is set. The returned targets were written.
+func (c *Configure) Publish(workspace, tag string, force bool) ([]string, error) {
+	c.mu.RLock()
+	paths := make(map[string]string, len(c.publishes[tag]))
+	for source, target := range c.publishes[tag] {
+		paths[source] = target
+	}
+	c.mu.RUnlock()
+	sources := make([]string, 0, len(paths))
+	for source := range paths {
+		sources = append(sources, source)
+	}
+	sort.Strings(sources)
+	var published []string
+	for _, source := range sources {
+		target := path.Join(workspace, paths[source])
+		if _, err := os.Stat(target); err == nil && !force {
+			continue
+		}
+		content, err := ioutil.ReadFile(source)
+		if err != nil {
+			return published, err
+		}
+		if err = os.MkdirAll(path.Dir(target), os.ModePerm); err != nil {
+			return published, err
+		}
+		if err = ioutil.WriteFile(target, content, 0644); err != nil {
+			return published, err
+		}
+		published = append(published, target)
+	}
+	return published, nil
+}
+
+// List the config.d files with a supported extension in lexical order.
+func configDirFiles(workspace string) ([]string, error) {
+	entries, err := ioutil.ReadDir(path.Join(workspace, ConfigDir))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var files []string
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		switch path.Ext(entry.Name()) {
+		case ".toml", ".yaml", ".yml", ".json", ".ini", ".hcl":
+			files = append(files, path.Join(workspace, ConfigDir, entry.Name()))
+		}
+	}
+	return files, nil
+}
@@ -0,0 +1,160 @@
+package config
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/urionz/ini/dotenv"
+	"github.com/urionz/ini/parser"
+)
+
+// Prefix marking an encrypted config value, eg: password = "enc:..."
+const EncryptedPrefix = "enc:"
+
+// Name of the encrypted counterpart of the .env file.
+const EncryptedEnvFile = ".env.encrypted"
+
+var errNoKey = errors.New("no encryption key configured, set APP_KEY or APP_KEY_FILE")
+
+// Read the encryption key from APP_KEY, or from the file named by
+// APP_KEY_FILE (defaults to app.key in the workspace). Any string is accepted,
+// the AES-256 key is derived from its SHA-256 digest. A nil key is returned
+// when none is configured.
+func LoadKey(workspace string) ([]byte, error) {
+	if key := dotenv.Get("APP_KEY"); key != "" {
+		return deriveKey(key), nil
+	}
+	file := dotenv.Get("APP_KEY_FILE", path.Join(workspace, "app.key"))
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return deriveKey(strings.TrimSpace(string(content))), nil
+}
+
+func deriveKey(material string) []byte {
+	sum := sha256.Sum256([]byte(material))
+	return sum[:]
+}
+
+// Encrypt plain with AES-GCM and return it in the enc:<base64> form.
+func Encrypt(key []byte, plain []byte) (string, error) {
+	if key == nil {
+		return "", errNoKey
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+	sealed := gcm.Seal(nonce, nonce, plain, nil)
+	return EncryptedPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt a value produced by Encrypt.
+func Decrypt(key []byte, value string) ([]byte, error) {
+	if key == nil {
+		return nil, errNoKey
+	}
+	if !strings.HasPrefix(value, EncryptedPrefix) {
+		return nil, fmt.Errorf("encrypted value must start with %q", EncryptedPrefix)
+	}
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(value, EncryptedPrefix))
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, errors.New("encrypted value is too short")
+	}
+	nonce, sealed := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	return gcm.Open(nil, nonce, sealed, nil)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Replace every enc: string in value with its decrypted text. Maps and
+// slices are copied rather than modified.
+func decryptValues(key []byte, path string, value interface{}) (interface{}, error) {
+	if children, ok := toStringMap(value); ok {
+		decrypted := make(map[string]interface{}, len(children))
+		for child, nested := range children {
+			plain, err := decryptValues(key, joinPath(path, child), nested)
+			if err != nil {
+				return nil, err
+			}
+			decrypted[child] = plain
+		}
+		return decrypted, nil
+	}
+	switch typed := value.(type) {
+	case []interface{}:
+		decrypted := make([]interface{}, len(typed))
+		for index, item := range typed {
+			plain, err := decryptValues(key, joinPath(path, fmt.Sprint(index)), item)
+			if err != nil {
+				return nil, err
+			}
+			decrypted[index] = plain
+		}
+		return decrypted, nil
+	case string:
+		if !strings.HasPrefix(typed, EncryptedPrefix) {
+			return typed, nil
+		}
+		plain, err := Decrypt(key, typed)
+		if err != nil {
+			return nil, fmt.Errorf("decrypt %s: %w", path, err)
+		}
+		return string(plain), nil
+	}
+	return value, nil
+}
+
+// Decrypt the workspace .env.encrypted file, when present, into the process env.
+func loadEncryptedEnv(workspace string, key []byte) error {
+	content, err := ioutil.ReadFile(path.Join(workspace, EncryptedEnvFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	plain, err := Decrypt(key, strings.TrimSpace(string(content)))
+	if err != nil {
+		return fmt.Errorf("decrypt %s: %w", EncryptedEnvFile, err)
+	}
+	p := parser.NewSimpled(parser.NoDefSection)
+	if err = p.ParseBytes(plain); err != nil {
+		return err
+	}
+	if values, ok := p.SimpleData()[p.DefSection]; ok {
+		return dotenv.LoadFromMap(values)
+	}
+	return nil
+}
@@ -1,40 +1,35 @@
 package config
 
 import (
-	"fmt"
-	"path"
+	"time"
 
 	"github.com/goava/di"
-	"github.com/urionz/config"
-	"github.com/urionz/config/hcl"
-	"github.com/urionz/config/ini"
-	"github.com/urionz/config/json"
-	"github.com/urionz/config/toml"
-	"github.com/urionz/config/yaml"
 	"github.com/urionz/goofy"
 	"github.com/urionz/ini/dotenv"
 )
 
 func NewServiceProvider(app goofy.IApplication) {
-	app.Provide(func() (*Configure, error) {
-		serve = &Configure{
-			Config: config.New("goofy"),
+	app.AddCommanders(
+		new(ShowCommand), new(GetCommand), new(ValidateCommand),
+		new(EncryptCommand), new(EnvEncryptCommand), new(EnvDecryptCommand),
+		new(CacheCommand), new(ClearCommand), new(PublishCommand),
+	)
+	app.Provide(func() (*Configure, func(), error) {
+		conf, err := NewConfigure(WithWorkspace(app.Workspace()), AsDefault())
+		if err != nil {
+			return nil, nil, err
 		}
 
-		serve.AddDriver(yaml.Driver)
-		serve.AddDriver(json.Driver)
-		serve.AddDriver(ini.Driver)
-		serve.AddDriver(hcl.Driver)
-		serve.AddDriver(toml.Driver)
-
-		dotenv.LoadExists(app.Workspace(), ".env")
-
-		envConfFile := dotenv.Get("APP_CONF", fmt.Sprintf("config.%s.toml", dotenv.Get("APP_ENV", "dev")))
-
-		if err := serve.LoadExists(path.Join(app.Workspace(), "config.toml"), path.Join(app.Workspace(), envConfFile)); err != nil {
-			return nil, err
+		// The watcher is stopped along with the application.
+		stop := func() {}
+		if dotenv.Bool("CONFIG_WATCH", false) {
+			interval, err := time.ParseDuration(dotenv.Get("CONFIG_WATCH_INTERVAL", "2s"))
+			if err != nil {
+				return nil, nil, err
+			}
+			stop = conf.Watch(interval)
 		}
 
-		return serve, nil
+		return conf, stop, nil
 	}, di.As(new(IConfig)))
 }
@@ -1,7 +1,11 @@
 package config_test
 
 import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 	"github.com/urionz/goofy"
@@ -29,3 +33,372 @@ func TestNewConfigServiceProvider(t *testing.T) {
 		}).Run()
 	})
 }
+
+func TestConfigureReload(t *testing.T) {
+	workspace, err := ioutil.TempDir("", "config")
+	require.NoError(t, err)
+	defer os.RemoveAll(workspace)
+	file := filepath.Join(workspace, "config.toml")
+	require.NoError(t, ioutil.WriteFile(file, []byte("[logger]\nlevel = \"debug\"\n"), 0644))
+
+	goofy.New(goofy.SetWorkspace(workspace)).AddServices(config.NewServiceProvider, func(conf config.IConfig) {
+		var changes int
+		var level string
+		conf.OnChange("logger.level", func(old, new config.IConfig) {
+			changes++
+			require.Equal(t, "debug", old.String("logger.level"))
+			level = new.String("logger.level")
+		})
+		conf.OnChange("cache", func(_, _ config.IConfig) {
+			t.Fatal("unchanged prefix must not be notified")
+		})
+
+		require.NoError(t, ioutil.WriteFile(file, []byte("[logger]\nlevel = \"warn\"\n"), 0644))
+		require.NoError(t, conf.Reload())
+		require.Equal(t, 1, changes)
+		require.Equal(t, "warn", level)
+		require.Equal(t, "warn", conf.String("logger.level"))
+
+		require.NoError(t, ioutil.WriteFile(file, []byte("[logger\nlevel = "), 0644))
+		require.Error(t, conf.Reload())
+		require.Equal(t, "warn", conf.String("logger.level"))
+		require.Equal(t, 1, changes)
+	}).Run()
+}
+
+func TestConfigureWatchStops(t *testing.T) {
+	workspace, err := ioutil.TempDir("", "config")
+	require.NoError(t, err)
+	defer os.RemoveAll(workspace)
+	file := filepath.Join(workspace, "config.toml")
+	require.NoError(t, ioutil.WriteFile(file, []byte("[app]\nname = \"first\"\n"), 0644))
+	os.Setenv("CONFIG_WATCH", "true")
+	os.Setenv("CONFIG_WATCH_INTERVAL", "10ms")
+	defer os.Unsetenv("CONFIG_WATCH")
+	defer os.Unsetenv("CONFIG_WATCH_INTERVAL")
+
+	app := goofy.New(goofy.SetWorkspace(workspace))
+	var conf config.IConfig
+	app.AddServices(config.NewServiceProvider, func(c config.IConfig) {
+		conf = c
+		require.NoError(t, ioutil.WriteFile(file, []byte("[app]\nname = \"second\"\n"), 0644))
+		require.Eventually(t, func() bool {
+			return conf.String("app.name") == "second"
+		}, time.Second, 10*time.Millisecond)
+	}).Run()
+
+	app.(*goofy.Application).Cleanup()
+	require.NoError(t, ioutil.WriteFile(file, []byte("[app]\nname = \"third, longer\"\n"), 0644))
+	time.Sleep(50 * time.Millisecond)
+	require.Equal(t, "second", conf.String("app.name"))
+}
+
+func TestConfigureEnvPrecedence(t *testing.T) {
+	workspace, err := ioutil.TempDir("", "config")
+	require.NoError(t, err)
+	defer os.RemoveAll(workspace)
+
+	defaults := filepath.Join(workspace, "defaults.yaml")
+	require.NoError(t, ioutil.WriteFile(defaults, []byte("database:\n  default: mysql\n  conns:\n    mysql:\n      host: default-host\n      charset: utf8\n"), 0644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(workspace, "config.toml"), []byte("[database.conns.mysql]\nhost = \"${CONFIG_TEST_HOST:-base-host}\"\nname = \"${CONFIG_TEST_NAME}\"\nport = ${CONFIG_TEST_PORT:-3306}\n"), 0644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(workspace, "config.dev.toml"), []byte("[database.conns.mysql]\nuser = \"dev\"\n"), 0644))
+
+	os.Setenv("CONFIG_TEST_NAME", "goofy")
+	os.Setenv("APP__DATABASE__CONNS__MYSQL__USER", "env-user")
+	defer os.Unsetenv("CONFIG_TEST_NAME")
+	defer os.Unsetenv("APP__DATABASE__CONNS__MYSQL__USER")
+
+	goofy.New(goofy.SetWorkspace(workspace)).AddServices(config.NewServiceProvider, func(conf config.IConfig) {
+		require.NoError(t, conf.LoadDefaults(defaults))
+		require.Equal(t, "mysql", conf.String("database.default"))
+		require.Equal(t, "utf8", conf.String("database.conns.mysql.charset"))
+		require.Equal(t, "base-host", conf.String("database.conns.mysql.host"))
+		require.Equal(t, "goofy", conf.String("database.conns.mysql.name"))
+		require.Equal(t, 3306, conf.Int("database.conns.mysql.port"))
+		require.Equal(t, "env-user", conf.String("database.conns.mysql.user"))
+
+		require.NoError(t, conf.Set("database.conns.mysql.user", "runtime"))
+		os.Setenv("CONFIG_TEST_HOST", "env-host")
+		defer os.Unsetenv("CONFIG_TEST_HOST")
+		require.NoError(t, conf.Reload())
+		require.Equal(t, "env-host", conf.String("database.conns.mysql.host"))
+		require.Equal(t, "runtime", conf.String("database.conns.mysql.user"))
+	}).Run()
+}
+
+type testConn struct {
+	Host    string        `config:"host" default:"localhost" validate:"required"`
+	Port    int           `config:"port" default:"3306" validate:"min=1,max=65535"`
+	Driver  string        `config:"driver" default:"mysql" validate:"oneof=mysql postgres"`
+	Timeout time.Duration `config:"timeout" default:"5s" validate:"max=1m"`
+	Tags    []string      `config:"tags" default:"a,b"`
+	Health  string        `config:"health" validate:"url"`
+}
+
+type testDatabase struct {
+	Default string               `config:"default" validate:"required"`
+	Conns   map[string]*testConn `config:"conns" validate:"required"`
+	Pool    struct {
+		Max int `config:"max" default:"10"`
+	} `config:"pool"`
+}
+
+func TestConfigureDecode(t *testing.T) {
+	workspace, err := ioutil.TempDir("", "config")
+	require.NoError(t, err)
+	defer os.RemoveAll(workspace)
+	file := filepath.Join(workspace, "config.toml")
+	require.NoError(t, ioutil.WriteFile(file, []byte("[database]\ndefault = \"mysql\"\n[database.conns.mysql]\nport = \"3307\"\ntimeout = \"30s\"\n"), 0644))
+
+	goofy.New(goofy.SetWorkspace(workspace)).AddServices(config.NewServiceProvider, func(conf config.IConfig) {
+		var database testDatabase
+		require.NoError(t, conf.Decode("database", &database))
+		require.Equal(t, "mysql", database.Default)
+		require.Equal(t, 10, database.Pool.Max)
+		require.Equal(t, &testConn{
+			Host:    "localhost",
+			Port:    3307,
+			Driver:  "mysql",
+			Timeout: 30 * time.Second,
+			Tags:    []string{"a", "b"},
+		}, database.Conns["mysql"])
+		require.NoError(t, conf.RegisterSection("database", new(testDatabase)))
+
+		require.NoError(t, ioutil.WriteFile(file, []byte("[database.conns.mysql]\nport = 70000\ndriver = \"oracle\"\ntimeout = \"2m\"\nhealth = \"localhost\"\n"), 0644))
+		err := conf.Reload()
+		require.Error(t, err)
+		require.ElementsMatch(t, []string{
+			"database.default",
+			"database.conns.mysql.port",
+			"database.conns.mysql.driver",
+			"database.conns.mysql.timeout",
+			"database.conns.mysql.health",
+		}, paths(err.(config.ValidationErrors)))
+		require.Equal(t, 3307, conf.Int("database.conns.mysql.port"))
+		require.NoError(t, conf.ValidateSections())
+
+		// Zero values given explicitly are checked too.
+		require.NoError(t, ioutil.WriteFile(file, []byte("[database]\ndefault = \"mysql\"\n[database.conns.mysql]\nport = 0\ndriver = \"\"\n"), 0644))
+		err = conf.Reload()
+		require.Error(t, err)
+		require.ElementsMatch(t, []string{
+			"database.conns.mysql.port",
+			"database.conns.mysql.driver",
+		}, paths(err.(config.ValidationErrors)))
+	}).Run()
+}
+
+func paths(errs config.ValidationErrors) []string {
+	var result []string
+	for _, err := range errs {
+		result = append(result, err.Path)
+	}
+	return result
+}
+
+func TestConfigCommands(t *testing.T) {
+	workspace, err := ioutil.TempDir("", "config")
+	require.NoError(t, err)
+	defer os.RemoveAll(workspace)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(workspace, "config.toml"), []byte("[database.conns.mysql]\nhost = \"base\"\npassword = \"secret\"\n"+
+		"[[database.replicas]]\npassword = \"hidden\"\n"), 0644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(workspace, "config.dev.toml"), []byte("[database.conns.mysql]\nhost = \"dev\"\n"), 0644))
+	os.Setenv("APP__DATABASE__CONNS__MYSQL__PORT", "3307")
+	defer os.Unsetenv("APP__DATABASE__CONNS__MYSQL__PORT")
+
+	app := goofy.New(goofy.SetWorkspace(workspace))
+	app.AddServices(config.NewServiceProvider, func(conf *config.Configure) {
+		require.Equal(t, map[string]string{
+			"database.conns.mysql.host":     filepath.Join(workspace, "config.dev.toml"),
+			"database.conns.mysql.password": filepath.Join(workspace, "config.toml"),
+			"database.conns.mysql.port":     "APP__DATABASE__CONNS__MYSQL__PORT",
+		}, conf.Origins("database.conns"))
+
+		_, output, err := app.Call("config:get", "database.conns.mysql")
+		require.NoError(t, err)
+		require.Contains(t, output, "config.dev.toml")
+		require.Contains(t, output, "APP__DATABASE__CONNS__MYSQL__PORT")
+		require.NotContains(t, output, "secret")
+
+		_, output, err = app.Call("config:show", "database", "--format=yaml")
+		require.NoError(t, err)
+		require.Contains(t, output, "host: dev")
+		require.NotContains(t, output, "secret")
+		require.NotContains(t, output, "hidden")
+
+		_, output, err = app.Call("config:show", "database.conns.mysql.password")
+		require.NoError(t, err)
+		require.NotContains(t, output, "secret")
+
+		conf.RegisterSection("database", new(testDatabase))
+		_, _, err = app.Call("config:validate")
+		require.Error(t, err)
+	}).Run()
+}
+
+func TestConfigureEncryptedValues(t *testing.T) {
+	workspace, err := ioutil.TempDir("", "config")
+	require.NoError(t, err)
+	defer os.RemoveAll(workspace)
+	os.Setenv("APP_KEY", "test-key")
+	defer os.Unsetenv("APP_KEY")
+	defer os.Unsetenv("CONFIG_TEST_SECRET")
+
+	key, err := config.LoadKey(workspace)
+	require.NoError(t, err)
+	password, err := config.Encrypt(key, []byte("root-password"))
+	require.NoError(t, err)
+	env, err := config.Encrypt(key, []byte("CONFIG_TEST_SECRET=from-encrypted-env\n"))
+	require.NoError(t, err)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(workspace, config.EncryptedEnvFile), []byte(env), 0600))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(workspace, "config.toml"), []byte("[database.conns.mysql]\npassword = \""+password+"\"\nsecret = \"${CONFIG_TEST_SECRET}\"\n"), 0644))
+
+	goofy.New(goofy.SetWorkspace(workspace)).AddServices(config.NewServiceProvider, func(conf config.IConfig) {
+		require.Equal(t, "root-password", conf.String("database.conns.mysql.password"))
+		require.Equal(t, "from-encrypted-env", conf.String("database.conns.mysql.secret"))
+	}).Run()
+
+	os.Setenv("APP_KEY", "wrong-key")
+	wrong, err := config.LoadKey(workspace)
+	require.NoError(t, err)
+	_, err = config.Decrypt(wrong, password)
+	require.Error(t, err)
+}
+
+func TestConfigureCache(t *testing.T) {
+	workspace, err := ioutil.TempDir("", "config")
+	require.NoError(t, err)
+	defer os.RemoveAll(workspace)
+	file := filepath.Join(workspace, "config.toml")
+	require.NoError(t, ioutil.WriteFile(file, []byte("[app]\nname = \"cached\"\nport = 3306\n"), 0644))
+
+	app := goofy.New(goofy.SetWorkspace(workspace))
+	app.AddServices(config.NewServiceProvider, func(conf *config.Configure) {
+		_, _, err := app.Call("config:cache")
+		require.NoError(t, err)
+	}).Run()
+	require.FileExists(t, filepath.Join(workspace, config.CachedConfigFile))
+
+	info, err := os.Stat(file)
+	require.NoError(t, err)
+	require.NoError(t, ioutil.WriteFile(file, []byte("[app]\nname = \"edited\"\nport = 3306\n"), 0644))
+	require.NoError(t, os.Chtimes(file, info.ModTime(), info.ModTime()))
+
+	goofy.New(goofy.SetWorkspace(workspace)).AddServices(config.NewServiceProvider, func(conf config.IConfig) {
+		require.Equal(t, "cached", conf.String("app.name"))
+		require.Equal(t, 3306, conf.Int("app.port"))
+	}).Run()
+
+	later := info.ModTime().Add(time.Second)
+	require.NoError(t, os.Chtimes(file, later, later))
+	goofy.New(goofy.SetWorkspace(workspace)).AddServices(config.NewServiceProvider, func(conf config.IConfig) {
+		require.Equal(t, "edited", conf.String("app.name"))
+	}).Run()
+
+	app = goofy.New(goofy.SetWorkspace(workspace))
+	app.AddServices(config.NewServiceProvider, func(conf config.IConfig) {
+		_, _, err := app.Call("config:clear")
+		require.NoError(t, err)
+	}).Run()
+	require.NoFileExists(t, filepath.Join(workspace, config.CachedConfigFile))
+}
+
+func TestConfigureCacheStaleness(t *testing.T) {
+	workspace, err := ioutil.TempDir("", "config")
+	require.NoError(t, err)
+	defer os.RemoveAll(workspace)
+	write := func(name, content string) {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(workspace, name), []byte(content), 0644))
+	}
+	write("config.toml", "[app]\nname = \"${CACHE_APP_NAME:-base}\"\nprofile = \"base\"\n")
+	write("config.prod.toml", "[app]\nprofile = \"prod\"\n")
+	write("config.canary.toml", "[app]\nprofile = \"canary\"\n")
+
+	os.Setenv("APP_PROFILES", "prod,canary")
+	defer os.Unsetenv("APP_PROFILES")
+	os.Setenv("CACHE_APP_NAME", "cached")
+	defer os.Unsetenv("CACHE_APP_NAME")
+	app := goofy.New(goofy.SetWorkspace(workspace))
+	app.AddServices(config.NewServiceProvider, func(conf *config.Configure) {
+		_, _, err := app.Call("config:cache")
+		require.NoError(t, err)
+	}).Run()
+
+	os.Setenv("APP_PROFILES", "prod")
+	goofy.New(goofy.SetWorkspace(workspace)).AddServices(config.NewServiceProvider, func(conf config.IConfig) {
+		require.Equal(t, "prod", conf.String("app.profile"))
+	}).Run()
+
+	os.Setenv("APP_PROFILES", "prod,canary")
+	os.Setenv("CACHE_APP_NAME", "changed")
+	goofy.New(goofy.SetWorkspace(workspace)).AddServices(config.NewServiceProvider, func(conf config.IConfig) {
+		require.Equal(t, "canary", conf.String("app.profile"))
+		require.Equal(t, "changed", conf.String("app.name"))
+	}).Run()
+}
+
+func TestConfigureProfiles(t *testing.T) {
+	workspace, err := ioutil.TempDir("", "config")
+	require.NoError(t, err)
+	defer os.RemoveAll(workspace)
+	vendor, err := ioutil.TempDir("", "vendor")
+	require.NoError(t, err)
+	defer os.RemoveAll(vendor)
+
+	require.NoError(t, os.MkdirAll(filepath.Join(workspace, config.ConfigDir), os.ModePerm))
+	write := func(name, content string) {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(workspace, name), []byte(content), 0644))
+	}
+	write("config.toml", "[app]\nbase = \"config.toml\"\nprofile = \"config.toml\"\n")
+	write("config.d/20-b.yaml", "app:\n  dir: 20-b\n")
+	write("config.d/10-a.toml", "[app]\nbase = \"10-a\"\ndir = \"10-a\"\nprofile = \"10-a\"\n")
+	write("config.prod.toml", "[app]\nprofile = \"prod\"\nregion = \"prod\"\n")
+	write("config.canary.toml", "[app]\nprofile = \"canary\"\n")
+	defaults := filepath.Join(vendor, "package.toml")
+	require.NoError(t, ioutil.WriteFile(defaults, []byte("[package]\nenabled = true\n"), 0644))
+
+	os.Setenv("APP_PROFILES", "prod, canary")
+	defer os.Unsetenv("APP_PROFILES")
+	require.Equal(t, []string{"prod", "canary"}, config.Profiles())
+
+	app := goofy.New(goofy.SetWorkspace(workspace))
+	app.AddServices(config.NewServiceProvider, func(conf config.IConfig) {
+		require.Equal(t, "config.toml", conf.String("app.base"))
+		require.Equal(t, "20-b", conf.String("app.dir"))
+		require.Equal(t, "prod", conf.String("app.region"))
+		require.Equal(t, "canary", conf.String("app.profile"))
+
+		require.NoError(t, conf.LoadDefaults(defaults))
+		require.True(t, conf.Bool("package.enabled"))
+		_, _, err := app.Call("vendor:publish", "--tag=config")
+		require.NoError(t, err)
+		published, err := ioutil.ReadFile(filepath.Join(workspace, config.ConfigDir, "package.toml"))
+		require.NoError(t, err)
+		require.Equal(t, "[package]\nenabled = true\n", string(published))
+	}).Run()
+}
+
+func TestNewConfigure(t *testing.T) {
+	facade := config.Default()
+	first, err := config.NewConfigure(config.WithData(map[string]interface{}{
+		"database": map[string]interface{}{"default": "mysql"},
+	}))
+	require.NoError(t, err)
+	second, err := config.NewConfigure(config.WithData(map[string]interface{}{
+		"database": map[string]interface{}{"default": "sqlite"},
+	}))
+	require.NoError(t, err)
+
+	require.Equal(t, "mysql", first.String("database.default"))
+	require.Equal(t, "sqlite", second.String("database.default"))
+	require.NoError(t, first.Set("database.default", "postgres"))
+	require.Equal(t, "sqlite", second.String("database.default"))
+	require.Equal(t, map[string]string{"database.default": "runtime"}, first.Origins("database"))
+	require.Same(t, facade, config.Default())
+
+	bound, err := config.NewConfigure(config.WithData(map[string]interface{}{"app": map[string]interface{}{"name": "facade"}}), config.AsDefault())
+	require.NoError(t, err)
+	defer config.SetDefault(facade)
+	require.Same(t, bound, config.Default())
+	require.Equal(t, "facade", config.String("app.name"))
+}
@@ -0,0 +1,185 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// struct tag holding the comma separated validation rules of a field
+const validateTag = "validate"
+
+// FieldError describes a single config value breaking one of its rules.
+type FieldError struct {
+	Path    string
+	Rule    string
+	Message string
+}
+
+func (err *FieldError) Error() string {
+	return fmt.Sprintf("%s: %s", err.Path, err.Message)
+}
+
+// ValidationErrors aggregates every rule broken while validating a config.
+type ValidationErrors []*FieldError
+
+func (errs ValidationErrors) Error() string {
+	messages := make([]string, len(errs))
+	for index, err := range errs {
+		messages[index] = err.Error()
+	}
+	return fmt.Sprintf("invalid config:\n  %s", strings.Join(messages, "\n  "))
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// Check every `validate` tag reachable from value, decoded from data. Supported
+// rules are required, min=N, max=N, oneof=a b c and url. min and max compare
+// numbers, durations, or the length of strings, slices and maps. Rules other
+// than required are skipped for keys missing from data, a zero value given
+// explicitly is checked like any other.
+func validate(path string, value reflect.Value, data interface{}) error {
+	var errs ValidationErrors
+	walk(path, value, data, &errs)
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func walk(path string, value reflect.Value, data interface{}, errs *ValidationErrors) {
+	for value.Kind() == reflect.Ptr || value.Kind() == reflect.Interface {
+		if value.IsNil() {
+			return
+		}
+		value = value.Elem()
+	}
+	switch value.Kind() {
+	case reflect.Struct:
+		src, _ := toStringMap(data)
+		for i := 0; i < value.NumField(); i++ {
+			field := value.Type().Field(i)
+			name, ok := fieldKey(field)
+			if !ok {
+				continue
+			}
+			fieldPath := joinPath(path, name)
+			_, fieldData, set := lookupKey(src, name)
+			if rules := field.Tag.Get(validateTag); rules != "" {
+				check(fieldPath, value.Field(i), set, rules, errs)
+			}
+			walk(fieldPath, value.Field(i), fieldData, errs)
+		}
+	case reflect.Map:
+		src, _ := toStringMap(data)
+		for _, key := range value.MapKeys() {
+			name := fmt.Sprint(key.Interface())
+			walk(joinPath(path, name), value.MapIndex(key), src[name], errs)
+		}
+	case reflect.Slice, reflect.Array:
+		items, _ := data.([]interface{})
+		for index := 0; index < value.Len(); index++ {
+			var item interface{}
+			if index < len(items) {
+				item = items[index]
+			}
+			walk(joinPath(path, strconv.Itoa(index)), value.Index(index), item, errs)
+		}
+	}
+}
+
+func check(path string, value reflect.Value, set bool, rules string, errs *ValidationErrors) {
+	for _, rule := range strings.Split(rules, ",") {
+		name, param := rule, ""
+		if index := strings.Index(rule, "="); index >= 0 {
+			name, param = rule[:index], rule[index+1:]
+		}
+		if message := checkRule(value, set, name, param); message != "" {
+			*errs = append(*errs, &FieldError{
+				Path:    path,
+				Rule:    name,
+				Message: message,
+			})
+		}
+	}
+}
+
+func checkRule(value reflect.Value, set bool, rule, param string) string {
+	if rule == "required" {
+		if !set || isEmpty(value) {
+			return "is required"
+		}
+		return ""
+	}
+	if !set {
+		return ""
+	}
+	switch rule {
+	case "min", "max":
+		actual, limit, err := measure(value, param)
+		if err != nil {
+			return fmt.Sprintf("invalid %s rule: %v", rule, err)
+		}
+		if rule == "min" && actual < limit {
+			return fmt.Sprintf("must be at least %s", param)
+		}
+		if rule == "max" && actual > limit {
+			return fmt.Sprintf("must be at most %s", param)
+		}
+	case "oneof":
+		actual := fmt.Sprint(value.Interface())
+		for _, allowed := range strings.Fields(param) {
+			if actual == allowed {
+				return ""
+			}
+		}
+		return fmt.Sprintf("must be one of [%s], got %q", param, actual)
+	case "url":
+		if u, err := url.Parse(fmt.Sprint(value.Interface())); err != nil || u.Scheme == "" || u.Host == "" {
+			return "must be an absolute url"
+		}
+	default:
+		return fmt.Sprintf("unknown rule %q", rule)
+	}
+	return ""
+}
+
+// Get the comparable size of value and the parsed limit.
+func measure(value reflect.Value, param string) (float64, float64, error) {
+	if value.Type() == durationType {
+		limit, err := time.ParseDuration(param)
+		return float64(value.Int()), float64(limit), err
+	}
+	limit, err := strconv.ParseFloat(param, 64)
+	switch value.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(value.Int()), limit, err
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(value.Uint()), limit, err
+	case reflect.Float32, reflect.Float64:
+		return value.Float(), limit, err
+	case reflect.String, reflect.Slice, reflect.Map, reflect.Array:
+		return float64(value.Len()), limit, err
+	}
+	return 0, 0, fmt.Errorf("unsupported type %s", value.Type())
+}
+
+func isEmpty(value reflect.Value) bool {
+	switch value.Kind() {
+	case reflect.Slice, reflect.Map:
+		return value.Len() == 0
+	case reflect.Invalid:
+		return true
+	}
+	return value.IsZero()
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
@@ -0,0 +1,86 @@
+package config
+
+import (
+	"os"
+	"reflect"
+	"time"
+
+	"github.com/urionz/color"
+)
+
+// ChangeHandler receives snapshots of the whole config before and after a reload.
+type ChangeHandler func(old, new IConfig)
+
+type listener struct {
+	prefix  string
+	handler ChangeHandler
+}
+
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+// Register a handler called after a reload changed any value under keyPrefix.
+// An empty prefix subscribes to every change.
+func (c *Configure) OnChange(keyPrefix string, handler ChangeHandler) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.listeners = append(c.listeners, &listener{
+		prefix:  keyPrefix,
+		handler: handler,
+	})
+}
+
+// Poll the loaded files every interval and reload when one of them changes.
+// Rejected reloads are logged and the previous config stays active.
+// The returned function stops watching.
+func (c *Configure) Watch(interval time.Duration) (stop func()) {
+	done := make(chan struct{})
+	// Stamped right away, a change made once Watch returned is never missed.
+	stamps := c.stamps()
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				current := c.stamps()
+				if reflect.DeepEqual(stamps, current) {
+					continue
+				}
+				stamps = current
+				if err := c.Reload(); err != nil {
+					color.Errorln("config reload rejected:", err)
+				}
+			}
+		}
+	}()
+	return func() {
+		close(done)
+	}
+}
+
+func (c *Configure) lookup(prefix string) interface{} {
+	if prefix == "" {
+		return c.Data()
+	}
+	return c.Get(prefix)
+}
+
+func (c *Configure) stamps() map[string]fileStamp {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	stamps := make(map[string]fileStamp, len(c.defaults)+len(c.files))
+	for _, file := range append(append([]string(nil), c.defaults...), c.files...) {
+		if info, err := os.Stat(file); err == nil {
+			stamps[file] = fileStamp{
+				modTime: info.ModTime(),
+				size:    info.Size(),
+			}
+		}
+	}
+	return stamps
+}
@@ -0,0 +1,273 @@
+package db
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+
+	"github.com/urionz/cobra"
+	"github.com/urionz/cobra/show"
+	"github.com/urionz/color"
+	"github.com/urionz/goofy"
+	"github.com/urionz/service/db/schema"
+	"gorm.io/gorm"
+)
+
+// Leading keywords of the statements returning rows, the others are executed
+// and report the number of affected rows.
+var queryKeywords = map[string]bool{
+	"select":   true,
+	"show":     true,
+	"with":     true,
+	"values":   true,
+	"explain":  true,
+	"describe": true,
+	"desc":     true,
+	"pragma":   true,
+}
+
+const cliHelp = `\d             列出数据表和视图
+\d <table>     查看数据表结构
+\c <name>      切换数据库连接
+\history       查看历史语句
+\? \h          查看帮助
+\q exit quit   退出
+以 ; 结尾的语句会被执行，可跨越多行输入。`
+
+type CliCommand struct {
+	connection string
+	execute    string
+	history    string
+}
+
+func (cmd *CliCommand) Handle(app goofy.IApplication) *cobra.Command {
+	home, _ := os.UserHomeDir()
+	command := &cobra.Command{
+		Use:     "db:cli",
+		Aliases: []string{"db"},
+		Short:   "打开数据库交互式命令行",
+		RunE: func(c *cobra.Command, args []string) error {
+			var manager *Manager
+			if err := app.Resolve(&manager); err != nil {
+				return err
+			}
+			session := &cliSession{
+				manager: manager,
+				name:    manager.connectionName([]string{cmd.connection}),
+				out:     c.OutOrStdout(),
+			}
+			if err := session.connect(session.name); err != nil {
+				color.Errorln(err)
+				return err
+			}
+			if cmd.execute != "" {
+				statements, rest := schema.Split(session.conn.Dialector.Name(), cmd.execute)
+				if rest = strings.TrimSpace(rest); rest != "" {
+					statements = append(statements, rest)
+				}
+				for _, statement := range statements {
+					if err := session.run(statement); err != nil {
+						color.Errorln(err)
+						return err
+					}
+				}
+				return nil
+			}
+			session.history = cmd.history
+			return session.loop(c.InOrStdin())
+		},
+	}
+
+	command.PersistentFlags().StringVarP(&cmd.connection, "connection", "c", "", "数据库连接，默认为 database.default")
+	command.PersistentFlags().StringVarP(&cmd.execute, "execute", "e", "", "执行语句后退出")
+	command.PersistentFlags().StringVar(&cmd.history, "history", filepath.Join(home, ".db_cli_history"), "历史记录文件，为空时不记录")
+
+	return command
+}
+
+type cliSession struct {
+	manager *Manager
+	name    string
+	conn    *gorm.DB
+	out     io.Writer
+	history string
+}
+
+func (s *cliSession) connect(name string) error {
+	conn, err := s.manager.ConnectionE(name)
+	if err != nil {
+		return err
+	}
+	s.name, s.conn = name, conn
+	return nil
+}
+
+// Read statements from in until it is exhausted or the session is quit.
+func (s *cliSession) loop(in io.Reader) error {
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	var buffer string
+	s.prompt(buffer)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.TrimSpace(buffer) == "" {
+			if quit, handled := s.shortcut(strings.TrimSpace(line)); quit {
+				return nil
+			} else if handled {
+				buffer = ""
+				s.prompt(buffer)
+				continue
+			}
+		}
+		var statements []string
+		statements, buffer = schema.Split(s.conn.Dialector.Name(), buffer+line+"\n")
+		for _, statement := range statements {
+			s.remember(statement)
+			if err := s.run(statement); err != nil {
+				color.Errorln(err)
+			}
+		}
+		s.prompt(buffer)
+	}
+	return scanner.Err()
+}
+
+func (s *cliSession) prompt(buffer string) {
+	if strings.TrimSpace(buffer) == "" {
+		fmt.Fprintf(s.out, "%s> ", s.name)
+		return
+	}
+	fmt.Fprintf(s.out, "%*s> ", len(s.name), "-")
+}
+
+// Handle a backslash command or an exit keyword, quit reports whether the
+// session should end.
+func (s *cliSession) shortcut(line string) (quit, handled bool) {
+	fields := strings.Fields(strings.TrimSuffix(line, ";"))
+	if len(fields) == 0 {
+		return false, false
+	}
+	switch strings.ToLower(fields[0]) {
+	case `\q`, "exit", "quit":
+		return true, true
+	case `\?`, `\h`, "help":
+		fmt.Fprintln(s.out, cliHelp)
+	case `\d`:
+		var err error
+		if len(fields) > 1 {
+			err = describeTable(s.out, s.conn, fields[1])
+		} else {
+			err = s.listTables()
+		}
+		if err != nil {
+			color.Errorln(err)
+		}
+	case `\c`:
+		if len(fields) < 2 {
+			fmt.Fprintln(s.out, "Connected to", s.name)
+		} else if err := s.connect(fields[1]); err != nil {
+			color.Errorln(err)
+		} else {
+			fmt.Fprintln(s.out, "Connected to", s.name)
+		}
+	case `\history`:
+		s.printHistory()
+	default:
+		if strings.HasPrefix(fields[0], `\`) {
+			color.Errorln("unknown command", fields[0], `, try \?`)
+			return false, true
+		}
+		return false, false
+	}
+	return false, true
+}
+
+func (s *cliSession) listTables() error {
+	tables, err := schema.Tables(s.conn)
+	if err != nil {
+		return err
+	}
+	views, err := schema.Views(s.conn)
+	if err != nil {
+		return err
+	}
+	rows := []string{"Name\tType"}
+	for _, table := range tables {
+		rows = append(rows, table+"\ttable")
+	}
+	for _, view := range views {
+		rows = append(rows, view+"\tview")
+	}
+	return show.TabWriter(s.out, rows).Flush()
+}
+
+// Run a statement, printing the rows it returns or the rows it affected.
+func (s *cliSession) run(statement string) error {
+	keyword := strings.TrimLeft(statement, " \t\r\n(")
+	if end := strings.IndexFunc(keyword, func(r rune) bool { return !unicode.IsLetter(r) }); end >= 0 {
+		keyword = keyword[:end]
+	}
+	if !queryKeywords[strings.ToLower(keyword)] {
+		result := s.conn.Exec(statement)
+		if result.Error != nil {
+			return result.Error
+		}
+		_, err := fmt.Fprintf(s.out, "OK, %d rows affected\n", result.RowsAffected)
+		return err
+	}
+	rows, err := s.conn.Raw(statement).Rows()
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	lines, err := tabulate(rows)
+	if err != nil {
+		return err
+	}
+	if err = show.TabWriter(s.out, lines).Flush(); err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(s.out, "(%d rows)\n", len(lines)-1)
+	return err
+}
+
+func formatCell(value interface{}) string {
+	var cell string
+	switch v := value.(type) {
+	case nil:
+		return "NULL"
+	case []byte:
+		cell = string(v)
+	default:
+		cell = fmt.Sprint(v)
+	}
+	return strings.NewReplacer("\t", " ", "\n", " ", "\r", " ").Replace(cell)
+}
+
+func (s *cliSession) remember(statement string) {
+	if s.history == "" {
+		return
+	}
+	file, err := os.OpenFile(s.history, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	fmt.Fprintln(file, strings.Join(strings.Fields(statement), " ")+";")
+}
+
+func (s *cliSession) printHistory() {
+	if s.history == "" {
+		return
+	}
+	file, err := os.Open(s.history)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	io.Copy(s.out, file)
+}
@@ -0,0 +1,303 @@
+package db
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/urionz/cobra"
+	"github.com/urionz/cobra/interact"
+	"github.com/urionz/cobra/show"
+	"github.com/urionz/color"
+	"github.com/urionz/goofy"
+	"github.com/urionz/service/config"
+	"github.com/urionz/service/db/schema"
+	"gorm.io/gorm"
+)
+
+type MonitorCommand struct {
+	databases []string
+	max       int
+}
+
+func (cmd *MonitorCommand) Handle(app goofy.IApplication) *cobra.Command {
+	command := &cobra.Command{
+		Use:   "db:monitor",
+		Short: "查看数据库连接池状态",
+		RunE: func(c *cobra.Command, args []string) error {
+			var manager *Manager
+			if err := app.Resolve(&manager); err != nil {
+				return err
+			}
+			databases := cmd.databases
+			if len(databases) == 0 {
+				databases = []string{manager.getDefaultConnection()}
+			}
+			rows := []string{"Connection\tOpen\tIn Use\tIdle\tMax Open\tWait Count\tWait Duration"}
+			var exceeded []string
+			for _, name := range databases {
+				stats, err := manager.Stats(name)
+				if err != nil {
+					color.Errorln(err)
+					return err
+				}
+				rows = append(rows, fmt.Sprintf(
+					"%s\t%d\t%d\t%d\t%d\t%d\t%s",
+					name, stats.OpenConnections, stats.InUse, stats.Idle,
+					stats.MaxOpenConnections, stats.WaitCount, stats.WaitDuration,
+				))
+				if cmd.max > 0 && stats.OpenConnections > cmd.max {
+					exceeded = append(exceeded, name)
+				}
+			}
+			if err := show.TabWriter(c.OutOrStdout(), rows).Flush(); err != nil {
+				return err
+			}
+			for _, name := range exceeded {
+				color.Warnln("Connection", name, "has more than", cmd.max, "open connections.")
+			}
+			return nil
+		},
+	}
+
+	command.PersistentFlags().StringSliceVarP(&cmd.databases, "database", "d", nil, "要查看的数据库连接")
+	command.PersistentFlags().IntVar(&cmd.max, "max", 0, "打开连接数超过该值时告警")
+
+	return command
+}
+
+type ShowCommand struct {
+	connection string
+}
+
+func (cmd *ShowCommand) Handle(app goofy.IApplication) *cobra.Command {
+	command := &cobra.Command{
+		Use:   "db:show",
+		Short: "查看数据库概况",
+		RunE: func(c *cobra.Command, args []string) error {
+			var manager *Manager
+			if err := app.Resolve(&manager); err != nil {
+				return err
+			}
+			rows, err := cmd.summary(manager)
+			if err != nil {
+				color.Errorln(err)
+				return err
+			}
+			return show.TabWriter(c.OutOrStdout(), rows).Flush()
+		},
+	}
+
+	command.PersistentFlags().StringVarP(&cmd.connection, "connection", "c", "", "数据库连接，默认为 database.default")
+
+	return command
+}
+
+func (cmd *ShowCommand) summary(manager *Manager) ([]string, error) {
+	name := manager.connectionName([]string{cmd.connection})
+	conn, err := manager.ConnectionE(name)
+	if err != nil {
+		return nil, err
+	}
+	conf, err := manager.getConfig(name)
+	if err != nil {
+		return nil, err
+	}
+	version, err := schema.Version(conn)
+	if err != nil {
+		return nil, err
+	}
+	size, err := schema.Size(conn)
+	if err != nil {
+		return nil, err
+	}
+	tables, err := schema.Tables(conn)
+	if err != nil {
+		return nil, err
+	}
+	views, err := schema.Views(conn)
+	if err != nil {
+		return nil, err
+	}
+	stats, err := manager.Stats(name)
+	if err != nil {
+		return nil, err
+	}
+	database := conf.Name
+	if conf.DSN != "" {
+		database = "(dsn)"
+	}
+	return []string{
+		"Connection\t" + name,
+		"Driver\t" + conf.Driver,
+		"Version\t" + version,
+		"Database\t" + database,
+		"Size\t" + formatBytes(size),
+		fmt.Sprintf("Tables\t%d", len(tables)),
+		fmt.Sprintf("Views\t%d", len(views)),
+		fmt.Sprintf("Open Connections\t%d", stats.OpenConnections),
+	}, nil
+}
+
+type TableCommand struct {
+	connection string
+}
+
+func (cmd *TableCommand) Handle(app goofy.IApplication) *cobra.Command {
+	command := &cobra.Command{
+		Use:   "db:table <name>",
+		Short: "查看数据表结构",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(c *cobra.Command, args []string) error {
+			var manager *Manager
+			if err := app.Resolve(&manager); err != nil {
+				return err
+			}
+			conn, err := manager.ConnectionE(cmd.connection)
+			if err != nil {
+				color.Errorln(err)
+				return err
+			}
+			if err = describeTable(c.OutOrStdout(), conn, args[0]); err != nil {
+				color.Errorln(err)
+				return err
+			}
+			return nil
+		},
+	}
+
+	command.PersistentFlags().StringVarP(&cmd.connection, "connection", "c", "", "数据库连接，默认为 database.default")
+
+	return command
+}
+
+// Print the columns, indexes, foreign keys and row count of a table.
+func describeTable(out io.Writer, conn *gorm.DB, table string) error {
+	if !conn.Migrator().HasTable(table) {
+		return fmt.Errorf("table %s does not exist", table)
+	}
+	columns, err := conn.Migrator().ColumnTypes(table)
+	if err != nil {
+		return err
+	}
+	indexes, err := schema.Indexes(conn, table)
+	if err != nil {
+		return err
+	}
+	keys, err := schema.ForeignKeys(conn, table)
+	if err != nil {
+		return err
+	}
+	var count int64
+	if err = conn.Table(table).Count(&count).Error; err != nil {
+		return err
+	}
+
+	rows := []string{"Column\tType\tNullable"}
+	for _, column := range columns {
+		nullable := "-"
+		if null, ok := column.Nullable(); ok {
+			nullable = strconv.FormatBool(null)
+		}
+		rows = append(rows, fmt.Sprintf("%s\t%s\t%s", column.Name(), column.DatabaseTypeName(), nullable))
+	}
+	if err = show.TabWriter(out, rows).Flush(); err != nil {
+		return err
+	}
+	if len(indexes) > 0 {
+		fmt.Fprintln(out)
+		rows = []string{"Index\tColumns\tUnique"}
+		for _, index := range indexes {
+			rows = append(rows, fmt.Sprintf("%s\t%s\t%t", index.Name, strings.Join(index.Columns, ", "), index.Unique))
+		}
+		if err = show.TabWriter(out, rows).Flush(); err != nil {
+			return err
+		}
+	}
+	if len(keys) > 0 {
+		fmt.Fprintln(out)
+		rows = []string{"Foreign Key\tColumns\tReferences"}
+		for _, key := range keys {
+			rows = append(rows, fmt.Sprintf(
+				"%s\t%s\t%s(%s)",
+				key.Name, strings.Join(key.Columns, ", "), key.ForeignTable, strings.Join(key.ForeignColumns, ", "),
+			))
+		}
+		if err = show.TabWriter(out, rows).Flush(); err != nil {
+			return err
+		}
+	}
+	_, err = fmt.Fprintf(out, "\nRows: %d\n", count)
+	return err
+}
+
+type WipeCommand struct {
+	connection string
+	dropViews  bool
+	force      bool
+}
+
+func (cmd *WipeCommand) Handle(app goofy.IApplication) *cobra.Command {
+	command := &cobra.Command{
+		Use:   "db:wipe",
+		Short: "删除所有数据表",
+		RunE: func(c *cobra.Command, args []string) error {
+			var manager *Manager
+			if err := app.Resolve(&manager); err != nil {
+				return err
+			}
+			name := manager.connectionName([]string{cmd.connection})
+			if !cmd.force && isProduction() {
+				color.Warnln("当前为生产环境，将删除连接", name, "的所有数据表，是否继续？")
+				if !interact.AnswerIsYes(false) {
+					return nil
+				}
+			}
+			conn, err := manager.ConnectionE(name)
+			if err != nil {
+				color.Errorln(err)
+				return err
+			}
+			tables, views, err := schema.Wipe(conn, cmd.dropViews)
+			if err != nil {
+				color.Errorln(err)
+				return err
+			}
+			if cmd.dropViews {
+				color.Infoln("Dropped", len(views), "views successfully.")
+			}
+			color.Infoln("Dropped", len(tables), "tables successfully.")
+			return nil
+		},
+	}
+
+	command.PersistentFlags().StringVarP(&cmd.connection, "connection", "c", "", "数据库连接，默认为 database.default")
+	command.PersistentFlags().BoolVar(&cmd.dropViews, "drop-views", false, "同时删除所有视图")
+	command.PersistentFlags().BoolVarP(&cmd.force, "force", "f", false, "生产环境下不再确认")
+
+	return command
+}
+
+// Whether any active config profile is a production one.
+func isProduction() bool {
+	for _, profile := range config.Profiles() {
+		if profile = strings.ToLower(profile); profile == "prod" || profile == "production" {
+			return true
+		}
+	}
+	return false
+}
+
+func formatBytes(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.2f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,68 @@
+package db
+
+import "time"
+
+// Config of the database section.
+type Config struct {
+	Default string                       `config:"default" validate:"required"`
+	Conns   map[string]*ConnectionConfig `config:"conns" validate:"required"`
+}
+
+// ConnectionConfig of a single database.conns.<name> entry.
+type ConnectionConfig struct {
+	Driver        string `config:"driver" default:"mysql" validate:"oneof=mysql postgres sqlite sqlserver"`
+	DSN           string `config:"dsn"`
+	Host          string `config:"host" default:"localhost"`
+	Port          int    `config:"port" validate:"min=1,max=65535"`
+	User          string `config:"user" default:"root"`
+	Password      string `config:"password" default:"root"`
+	Name          string `config:"name" default:"test"`
+	Charset       string `config:"charset" default:"utf8mb4"`
+	Prefix        string `config:"prefix"`
+	SingularTable bool   `config:"singular_table"`
+	SlowThreshold int    `config:"slow_threshold" default:"100" validate:"min=0"`
+	LogLevel      string `config:"log_level" default:"info" validate:"oneof=silent error warn info"`
+	// Mask string literals in logged sql.
+	Redact       bool `config:"redact"`
+	MaxOpenConns int  `config:"max_open_conns" default:"10" validate:"min=0"`
+	MaxIdleConns int  `config:"max_idle_conns" default:"10" validate:"min=0"`
+
+	ConnMaxLifetime time.Duration `config:"conn_max_lifetime" validate:"min=0"`
+	ConnMaxIdleTime time.Duration `config:"conn_max_idle_time" validate:"min=0"`
+	// Read and write timeouts are only supported by the mysql driver.
+	DialTimeout  time.Duration `config:"dial_timeout" validate:"min=0"`
+	ReadTimeout  time.Duration `config:"read_timeout" validate:"min=0"`
+	WriteTimeout time.Duration `config:"write_timeout" validate:"min=0"`
+	TLS          TLSConfig     `config:"tls"`
+	// Extra driver params appended to the generated dsn.
+	Params map[string]string `config:"params"`
+
+	// Read replicas and write hosts, each inheriting the settings above.
+	Read   []*HostConfig `config:"read"`
+	Write  []*HostConfig `config:"write"`
+	Policy string        `config:"policy" default:"random" validate:"oneof=random round_robin"`
+	Sticky bool          `config:"sticky"`
+
+	// Health check settings, replicas lagging more than MaxReplicationLag
+	// behind are reported down, 0 disables the lag check.
+	PingTimeout       time.Duration `config:"ping_timeout" default:"5s" validate:"min=0"`
+	MaxReplicationLag time.Duration `config:"max_replication_lag" validate:"min=0"`
+}
+
+// HostConfig of a single read or write host of a connection.
+type HostConfig struct {
+	DSN      string `config:"dsn"`
+	Host     string `config:"host"`
+	Port     int    `config:"port" validate:"min=1,max=65535"`
+	User     string `config:"user"`
+	Password string `config:"password"`
+}
+
+// TLSConfig of a connection, modes follow postgres sslmode naming.
+type TLSConfig struct {
+	Mode       string `config:"mode" default:"disable" validate:"oneof=disable require verify-ca verify-full"`
+	CA         string `config:"ca"`
+	Cert       string `config:"cert"`
+	Key        string `config:"key"`
+	ServerName string `config:"server_name"`
+}
@@ -0,0 +1,191 @@
+package db
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/driver/mysql"
+	"gorm.io/driver/postgres"
+	"gorm.io/driver/sqlite"
+	"gorm.io/driver/sqlserver"
+	"gorm.io/gorm"
+)
+
+const (
+	DriverMysql     = "mysql"
+	DriverPostgres  = "postgres"
+	DriverSqlite    = "sqlite"
+	DriverSqlserver = "sqlserver"
+)
+
+var defaultPorts = map[string]int{
+	DriverMysql:     3306,
+	DriverPostgres:  5432,
+	DriverSqlserver: 1433,
+}
+
+// Build the gorm dialector of the configured driver.
+func (conf *ConnectionConfig) dialector() (gorm.Dialector, error) {
+	dsn, err := conf.dsn()
+	if err != nil {
+		return nil, err
+	}
+	switch conf.Driver {
+	case DriverMysql:
+		return mysql.Open(dsn), nil
+	case DriverPostgres:
+		return postgres.Open(dsn), nil
+	case DriverSqlite:
+		return sqlite.Open(dsn), nil
+	case DriverSqlserver:
+		return sqlserver.Open(dsn), nil
+	}
+	return nil, fmt.Errorf("unsupported database driver %q", conf.Driver)
+}
+
+// Get the data source name, an explicit dsn wins over the discrete settings.
+// Extra params are applied last so they can override any generated one.
+func (conf *ConnectionConfig) dsn() (string, error) {
+	if conf.DSN != "" {
+		return conf.DSN, nil
+	}
+	port := conf.Port
+	if port == 0 {
+		port = defaultPorts[conf.Driver]
+	}
+	switch conf.Driver {
+	case DriverPostgres:
+		return conf.postgresDSN(port), nil
+	case DriverSqlite:
+		return conf.sqliteDSN(), nil
+	case DriverSqlserver:
+		return conf.sqlserverDSN(port), nil
+	}
+	return conf.mysqlDSN(port)
+}
+
+func (conf *ConnectionConfig) mysqlDSN(port int) (string, error) {
+	params := url.Values{
+		"charset":   {conf.Charset},
+		"parseTime": {"True"},
+		"loc":       {"Local"},
+	}
+	setDuration(params, "timeout", conf.DialTimeout, time.Duration.String)
+	setDuration(params, "readTimeout", conf.ReadTimeout, time.Duration.String)
+	setDuration(params, "writeTimeout", conf.WriteTimeout, time.Duration.String)
+	name, err := conf.TLS.registerMysql(conf.Host, port)
+	if err != nil {
+		return "", err
+	}
+	if name != "" {
+		params.Set("tls", name)
+	}
+	for key, value := range conf.Params {
+		params.Set(key, value)
+	}
+	return fmt.Sprintf(
+		"%s:%s@tcp(%s)/%s?%s",
+		conf.User, conf.Password, net.JoinHostPort(conf.Host, strconv.Itoa(port)), conf.Name, params.Encode(),
+	), nil
+}
+
+func (conf *ConnectionConfig) postgresDSN(port int) string {
+	params := map[string]string{
+		"host":     conf.Host,
+		"port":     strconv.Itoa(port),
+		"user":     conf.User,
+		"password": conf.Password,
+		"dbname":   conf.Name,
+		"sslmode":  conf.TLS.Mode,
+		"TimeZone": "Local",
+	}
+	if conf.DialTimeout > 0 {
+		params["connect_timeout"] = strconv.Itoa(seconds(conf.DialTimeout))
+	}
+	for key, value := range map[string]string{
+		"sslrootcert": conf.TLS.CA,
+		"sslcert":     conf.TLS.Cert,
+		"sslkey":      conf.TLS.Key,
+	} {
+		if value != "" {
+			params[key] = value
+		}
+	}
+	for key, value := range conf.Params {
+		params[key] = value
+	}
+	keys := make([]string, 0, len(params))
+	for key := range params {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, 0, len(keys))
+	for _, key := range keys {
+		pairs = append(pairs, key+"="+quotePostgres(params[key]))
+	}
+	return strings.Join(pairs, " ")
+}
+
+func (conf *ConnectionConfig) sqliteDSN() string {
+	if len(conf.Params) == 0 {
+		return conf.Name
+	}
+	params := url.Values{}
+	for key, value := range conf.Params {
+		params.Set(key, value)
+	}
+	return conf.Name + "?" + params.Encode()
+}
+
+func (conf *ConnectionConfig) sqlserverDSN(port int) string {
+	params := url.Values{"database": {conf.Name}}
+	setDuration(params, "dial timeout", conf.DialTimeout, func(d time.Duration) string {
+		return strconv.Itoa(seconds(d))
+	})
+	switch conf.TLS.Mode {
+	case TLSRequire:
+		params.Set("encrypt", "true")
+		params.Set("TrustServerCertificate", "true")
+	case TLSVerifyCA, TLSVerifyFull:
+		params.Set("encrypt", "true")
+		if conf.TLS.CA != "" {
+			params.Set("certificate", conf.TLS.CA)
+		}
+		if conf.TLS.Mode == TLSVerifyFull {
+			params.Set("hostNameInCertificate", conf.TLS.serverName(conf.Host))
+		}
+	}
+	for key, value := range conf.Params {
+		params.Set(key, value)
+	}
+	dsn := url.URL{
+		Scheme:   "sqlserver",
+		User:     url.UserPassword(conf.User, conf.Password),
+		Host:     net.JoinHostPort(conf.Host, strconv.Itoa(port)),
+		RawQuery: params.Encode(),
+	}
+	return dsn.String()
+}
+
+func setDuration(params url.Values, key string, value time.Duration, format func(time.Duration) string) {
+	if value > 0 {
+		params.Set(key, format(value))
+	}
+}
+
+// Round a timeout up to whole seconds, drivers taking seconds treat 0 as none.
+func seconds(d time.Duration) int {
+	return int((d + time.Second - 1) / time.Second)
+}
+
+func quotePostgres(value string) string {
+	if value != "" && !strings.ContainsAny(value, ` '\`) {
+		return value
+	}
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(value) + "'"
+}
@@ -0,0 +1,144 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/urionz/service/health"
+)
+
+var _ health.Source = (*Manager)(nil)
+
+// Ping every pool of the given connections, replicas included, and check the
+// replicas do not lag further behind than max_replication_lag. Every
+// configured connection is pinged when none is given. Each connection gets
+// ping_timeout to answer, unless ctx expires first.
+func (m *Manager) Ping(ctx context.Context, names ...string) error {
+	if len(names) == 0 {
+		names = m.Names()
+	}
+	var failed []string
+	for _, name := range names {
+		if err := m.ping(ctx, m.connectionName([]string{name})); err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", name, err))
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("database ping failed: %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+func (m *Manager) ping(ctx context.Context, name string) error {
+	conf, err := m.getConfig(name)
+	if err != nil {
+		return err
+	}
+	if conf.PingTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, conf.PingTimeout)
+		defer cancel()
+	}
+	// Opening the connection takes no context, it is waited for under the
+	// timeout too and left to finish in the background.
+	resolved := make(chan error, 1)
+	var conn *connection
+	go func() {
+		var err error
+		conn, err = m.connection(name)
+		resolved <- err
+	}()
+	select {
+	case err = <-resolved:
+		if err != nil {
+			return err
+		}
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	for _, pool := range conn.pools {
+		if err = pool.PingContext(ctx); err != nil {
+			return err
+		}
+	}
+	if conf.MaxReplicationLag <= 0 {
+		return nil
+	}
+	for i, replica := range conn.replicas {
+		lag, err := replicationLag(ctx, conf.Driver, replica)
+		if err != nil {
+			return fmt.Errorf("replica %d: %w", i, err)
+		}
+		if lag > conf.MaxReplicationLag {
+			return fmt.Errorf("replica %d lags %s behind", i, lag)
+		}
+	}
+	return nil
+}
+
+// Get how far behind its primary a replica is, 0 when the dialect can't tell.
+func replicationLag(ctx context.Context, driver string, pool *sql.DB) (time.Duration, error) {
+	switch driver {
+	case DriverMysql:
+		return mysqlReplicationLag(ctx, pool)
+	case DriverPostgres:
+		var seconds float64
+		err := pool.QueryRowContext(ctx, "SELECT CASE WHEN pg_is_in_recovery() "+
+			"THEN COALESCE(EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp()), 0) ELSE 0 END",
+		).Scan(&seconds)
+		return time.Duration(seconds * float64(time.Second)), err
+	}
+	return 0, nil
+}
+
+func mysqlReplicationLag(ctx context.Context, pool *sql.DB) (time.Duration, error) {
+	rows, err := pool.QueryContext(ctx, "SHOW SLAVE STATUS")
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		// Not a replica.
+		return 0, rows.Err()
+	}
+	columns, err := rows.Columns()
+	if err != nil {
+		return 0, err
+	}
+	values := make([]interface{}, len(columns))
+	lag := -1
+	var seconds sql.NullInt64
+	for i, column := range columns {
+		if column == "Seconds_Behind_Master" {
+			values[i], lag = &seconds, i
+		} else {
+			values[i] = new(sql.RawBytes)
+		}
+	}
+	if lag < 0 {
+		return 0, errors.New("replication status has no Seconds_Behind_Master")
+	}
+	if err = rows.Scan(values...); err != nil {
+		return 0, err
+	}
+	if !seconds.Valid {
+		return 0, errors.New("replication is not running")
+	}
+	return time.Duration(seconds.Int64) * time.Second, nil
+}
+
+// Get a check pinging each configured connection, named db.<connection>.
+func (m *Manager) HealthChecks() map[string]health.CheckFunc {
+	checks := make(map[string]health.CheckFunc)
+	for _, name := range m.Names() {
+		name := name
+		checks["db."+name] = func(ctx context.Context) error {
+			return m.Ping(ctx, name)
+		}
+	}
+	return checks
+}
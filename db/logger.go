@@ -0,0 +1,137 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/urionz/service/log"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+var logLevels = map[string]logger.LogLevel{
+	"silent": logger.Silent,
+	"error":  logger.Error,
+	"warn":   logger.Warn,
+	"info":   logger.Info,
+}
+
+// String literals in explained sql, keyed by the quote the dialect uses.
+var literalPatterns = map[string]*regexp.Regexp{
+	`'`: regexp.MustCompile(`'(?:[^'\\]|\\.|'')*'`),
+	`"`: regexp.MustCompile(`"(?:[^"\\]|\\.)*"`),
+}
+
+var sourceDir = func() string {
+	_, file, _, _ := runtime.Caller(0)
+	return path.Dir(file) + "/"
+}()
+
+// Get the first caller outside of gorm, its plugins and this package.
+func caller() string {
+	for i := 2; i < 20; i++ {
+		_, file, line, ok := runtime.Caller(i)
+		if !ok {
+			break
+		}
+		internal := strings.Contains(file, "gorm.io/") ||
+			(strings.HasPrefix(file, sourceDir) && !strings.HasSuffix(file, "_test.go"))
+		if !internal {
+			return file + ":" + strconv.Itoa(line)
+		}
+	}
+	return ""
+}
+
+// Gorm logger writing to the service logger, so sql ends up in the same
+// rotated files as the rest of the application.
+type gormLogger struct {
+	*zap.Logger
+	level         logger.LogLevel
+	slowThreshold time.Duration
+	literals      *regexp.Regexp
+}
+
+var _ logger.Interface = (*gormLogger)(nil)
+
+func (conf *ConnectionConfig) newLogger(name string, base *log.Logger) logger.Interface {
+	if base == nil {
+		base = log.Default()
+	}
+	l := &gormLogger{
+		// The caller is added as a field, zap would only ever see this file.
+		Logger:        base.Logger.WithOptions(zap.WithCaller(false)).With(zap.String("connection", name)),
+		level:         logLevels[conf.LogLevel],
+		slowThreshold: time.Duration(conf.SlowThreshold) * time.Millisecond,
+	}
+	if conf.Redact {
+		quote := `'`
+		if conf.Driver == DriverSqlite {
+			quote = `"`
+		}
+		l.literals = literalPatterns[quote]
+	}
+	return l
+}
+
+func (l *gormLogger) LogMode(level logger.LogLevel) logger.Interface {
+	mode := *l
+	mode.level = level
+	return &mode
+}
+
+func (l *gormLogger) Info(_ context.Context, msg string, data ...interface{}) {
+	if l.level >= logger.Info {
+		l.Logger.Info(fmt.Sprintf(msg, data...), zap.String("caller", caller()))
+	}
+}
+
+func (l *gormLogger) Warn(_ context.Context, msg string, data ...interface{}) {
+	if l.level >= logger.Warn {
+		l.Logger.Warn(fmt.Sprintf(msg, data...), zap.String("caller", caller()))
+	}
+}
+
+func (l *gormLogger) Error(_ context.Context, msg string, data ...interface{}) {
+	if l.level >= logger.Error {
+		l.Logger.Error(fmt.Sprintf(msg, data...), zap.String("caller", caller()))
+	}
+}
+
+func (l *gormLogger) Trace(_ context.Context, begin time.Time, fc func() (string, int64), err error) {
+	if l.level <= logger.Silent {
+		return
+	}
+	elapsed := time.Since(begin)
+	slow := l.slowThreshold > 0 && elapsed > l.slowThreshold
+	switch {
+	// A missing record is a result, not a failure, like gorm's IgnoreRecordNotFoundError.
+	case err != nil && !errors.Is(err, gorm.ErrRecordNotFound) && l.level >= logger.Error:
+		l.Logger.Error(err.Error(), l.fields(fc, elapsed)...)
+	case slow && l.level >= logger.Warn:
+		l.Logger.Warn(fmt.Sprintf("slow sql >= %v", l.slowThreshold), l.fields(fc, elapsed)...)
+	case l.level >= logger.Info:
+		l.Logger.Info("sql", l.fields(fc, elapsed)...)
+	}
+}
+
+func (l *gormLogger) fields(fc func() (string, int64), elapsed time.Duration) []zap.Field {
+	sql, rows := fc()
+	if l.literals != nil {
+		sql = l.literals.ReplaceAllString(sql, "'?'")
+	}
+	return []zap.Field{
+		zap.String("sql", sql),
+		zap.Duration("duration", elapsed),
+		zap.Int64("rows", rows),
+		zap.String("caller", caller()),
+	}
+}
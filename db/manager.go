@@ -2,27 +2,39 @@ package db
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
-	"io"
-	"log"
-	"os"
+	"sort"
+	"strings"
 	"sync"
-	"time"
 
 	"github.com/urionz/service/config"
-	"gorm.io/driver/mysql"
+	"github.com/urionz/service/db/migrate"
+	"github.com/urionz/service/log"
 	"gorm.io/gorm"
-	"gorm.io/gorm/logger"
 	"gorm.io/gorm/schema"
 )
 
 type Factory interface {
 	Connection(...string) *gorm.DB
+	ConnectionE(...string) (*gorm.DB, error)
 }
 
+var _ migrate.Factory = (*Manager)(nil)
+
 type Manager struct {
 	connections sync.Map
+	mu          sync.Mutex
 	conf        config.IConfig
+	logger      *log.Logger
+	metrics     *Metrics
+}
+
+// A resolved connection with every pool opened for it, replicas included.
+type connection struct {
+	db       *gorm.DB
+	pools    []*sql.DB
+	replicas []*sql.DB
 }
 
 var _ Factory = (*Manager)(nil)
@@ -33,68 +45,223 @@ func NewManager(conf config.IConfig) *Manager {
 	}
 }
 
+// Set the logger sql is written to, the default logger is used otherwise.
+func (m *Manager) SetLogger(logger *log.Logger) *Manager {
+	m.logger = logger
+	return m
+}
+
+// Set the metrics the queries of connections resolved from now on are
+// recorded in.
+func (m *Manager) SetMetrics(metrics *Metrics) *Manager {
+	m.metrics = metrics
+	return m
+}
+
+// Get a connection, the error is logged and nil returned when it can't be
+// resolved. Use ConnectionE to handle the error instead.
 func (m *Manager) Connection(names ...string) *gorm.DB {
-	var conn *gorm.DB
-	var err error
-	if len(names) == 0 {
-		names = append(names, m.getDefaultConnection())
+	conn, err := m.ConnectionE(names...)
+	if err != nil {
+		log.Error(err)
+		return nil
 	}
-	if conn, ok := m.connections.Load(names[0]); ok {
-		return conn.(*gorm.DB)
+	return conn
+}
+
+// Get a connection, resolving it on first use. The default connection is used
+// when no name is given.
+func (m *Manager) ConnectionE(names ...string) (*gorm.DB, error) {
+	conn, err := m.connection(m.connectionName(names))
+	if err != nil {
+		return nil, err
+	}
+	return conn.db, nil
+}
+
+func (m *Manager) connection(name string) (*connection, error) {
+	if conn, ok := m.connections.Load(name); ok {
+		return conn.(*connection), nil
 	}
-	if conn, err = m.resolve(names[0]); err != nil {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if conn, ok := m.connections.Load(name); ok {
+		return conn.(*connection), nil
+	}
+	conn, err := m.resolve(name)
+	if err != nil {
+		return nil, fmt.Errorf("database connection %s: %w", name, err)
+	}
+	m.connections.Store(name, conn)
+	return conn, nil
+}
+
+// Get the names of every configured connection.
+func (m *Manager) Names() []string {
+	var conns map[string]interface{}
+	if err := m.conf.Decode("database.conns", &conns); err != nil {
 		return nil
 	}
-	m.connections.Store(names[0], conn)
-	return conn
+	names := make([]string, 0, len(conns))
+	for name := range conns {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Close the pools of a connection and forget it, the next call to Connection
+// resolves it again with the current config.
+func (m *Manager) Disconnect(names ...string) error {
+	name := m.connectionName(names)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	conn, ok := m.connections.Load(name)
+	if !ok {
+		return nil
+	}
+	m.connections.Delete(name)
+	return conn.(*connection).close()
+}
+
+// Disconnect a connection and resolve it again right away.
+func (m *Manager) Reconnect(names ...string) (*gorm.DB, error) {
+	name := m.connectionName(names)
+	if err := m.Disconnect(name); err != nil {
+		return nil, err
+	}
+	return m.ConnectionE(name)
+}
+
+// Disconnect the given connections, or every resolved one when none is given.
+func (m *Manager) Purge(names ...string) error {
+	if len(names) == 0 {
+		m.connections.Range(func(name, _ interface{}) bool {
+			names = append(names, name.(string))
+			return true
+		})
+	}
+	var failed []string
+	for _, name := range names {
+		if err := m.Disconnect(name); err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", name, err))
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to close database connections: %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// Close every resolved connection, called when the application shuts down.
+func (m *Manager) CloseAll() error {
+	return m.Purge()
+}
+
+func (m *Manager) connectionName(names []string) string {
+	if len(names) == 0 || names[0] == "" {
+		return m.getDefaultConnection()
+	}
+	return names[0]
+}
+
+func (conn *connection) track(pools ...*sql.DB) {
+	for _, pool := range pools {
+		tracked := false
+		for _, known := range conn.pools {
+			tracked = tracked || known == pool
+		}
+		if !tracked {
+			conn.pools = append(conn.pools, pool)
+		}
+	}
 }
 
-func (m *Manager) resolve(name string) (conn *gorm.DB, err error) {
+func (conn *connection) close() error {
+	var err error
+	for _, pool := range conn.pools {
+		if closeErr := pool.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+func (m *Manager) resolve(name string) (*connection, error) {
+	var err error
+	var conn *gorm.DB
 	var db *sql.DB
-	conf := m.getConfig(name)
-	writes := []io.Writer{
-		os.Stdout,
-	}
-	if conn, err = gorm.Open(mysql.Open(
-		fmt.Sprintf(
-			"%s:%s@tcp(%s:%d)/%s?charset=%s&parseTime=True&loc=Local",
-			conf.String("user", "root"),
-			conf.String("password", "root"),
-			conf.String("host", "localhost"),
-			conf.Int("port", 3306),
-			conf.String("name", "test"),
-			conf.String("charset", "utf8mb4"),
-		),
-	), &gorm.Config{
+	var conf *ConnectionConfig
+	var dialector gorm.Dialector
+	var pools []*sql.DB
+	if conf, err = m.getConfig(name); err != nil {
+		return nil, err
+	}
+	if dialector, err = conf.primary().dialector(); err != nil {
+		return nil, err
+	}
+	if conn, err = gorm.Open(dialector, &gorm.Config{
 		NamingStrategy: schema.NamingStrategy{
-			TablePrefix:   conf.String("prefix", ""),
-			SingularTable: conf.Bool("singular_table", false),
+			TablePrefix:   conf.Prefix,
+			SingularTable: conf.SingularTable,
 		},
-		Logger: logger.New(
-			log.New(io.MultiWriter(writes...), "\r\n", log.LstdFlags),
-			logger.Config{
-				SlowThreshold: time.Duration(conf.Int("slow_threshold", 100)) * time.Millisecond,
-				LogLevel:      logger.Info,
-				Colorful:      true,
-			},
-		),
+		Logger: conf.newLogger(name, m.logger),
 	}); err != nil {
 		return nil, err
 	}
 	if db, err = conn.DB(); err != nil {
 		return nil, err
 	}
+	resolved := &connection{db: conn, pools: []*sql.DB{db}}
+	if err = m.instrument(name, conf, conn); err != nil {
+		resolved.close()
+		return nil, err
+	}
+	pools, err = conf.registerResolver(conn)
+	resolved.track(pools...)
+	if err != nil {
+		resolved.close()
+		return nil, err
+	}
+	if len(conf.Read) > 0 && len(pools) >= len(conf.Read) {
+		resolved.replicas = pools[len(pools)-len(conf.Read):]
+	}
 
-	db.SetMaxIdleConns(conf.Int("max_open_conns", 10))
-	db.SetMaxOpenConns(conf.Int("max_idle_conns", 10))
+	conf.configurePool(db)
 
-	return
+	return resolved, nil
+}
+
+// Get the pool statistics of the primary pool of a connection.
+func (m *Manager) Stats(names ...string) (sql.DBStats, error) {
+	conn, err := m.ConnectionE(names...)
+	if err != nil {
+		return sql.DBStats{}, err
+	}
+	db, err := conn.DB()
+	if err != nil {
+		return sql.DBStats{}, err
+	}
+	return db.Stats(), nil
+}
+
+// Get the name of the default connection.
+func (m *Manager) DefaultName() string {
+	return m.getDefaultConnection()
 }
 
 func (m *Manager) getDefaultConnection() string {
 	return m.conf.String("database.default")
 }
 
-func (m *Manager) getConfig(name string) config.IConfig {
-	return m.conf.Object(fmt.Sprintf("database.conns.%s", name))
+func (m *Manager) getConfig(name string) (*ConnectionConfig, error) {
+	// Decoding a missing connection gives the defaults, a local server.
+	if !m.conf.Exists(fmt.Sprintf("database.conns.%s", name)) {
+		return nil, errors.New("not configured")
+	}
+	conf := new(ConnectionConfig)
+	if err := m.conf.Decode(fmt.Sprintf("database.conns.%s", name), conf); err != nil {
+		return nil, err
+	}
+	return conf, nil
 }
@@ -0,0 +1,220 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/urionz/service/log"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// Name of the log channel slow queries are written to.
+const SlowQueryChannel = "slow_query"
+
+const startedAtKey = "service:started_at"
+
+// Statements EXPLAIN supports.
+var explainKeywords = map[string]bool{
+	"select":  true,
+	"insert":  true,
+	"update":  true,
+	"delete":  true,
+	"replace": true,
+	"with":    true,
+}
+
+// Query metrics of every connection, labelled by connection, operation and
+// table.
+type Metrics struct {
+	queries  *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+	errors   *prometheus.CounterVec
+	rows     *prometheus.CounterVec
+}
+
+// Create the query metrics and register them with registerer. Metrics already
+// registered by another manager are shared with it.
+func NewMetrics(registerer prometheus.Registerer) (*Metrics, error) {
+	labels := []string{"connection", "operation", "table"}
+	queries, err := register(registerer, prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "db_queries_total",
+		Help: "Number of executed queries.",
+	}, labels))
+	if err != nil {
+		return nil, err
+	}
+	duration, err := register(registerer, prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "db_query_duration_seconds",
+		Help:    "Latency of executed queries.",
+		Buckets: prometheus.DefBuckets,
+	}, labels))
+	if err != nil {
+		return nil, err
+	}
+	failures, err := register(registerer, prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "db_query_errors_total",
+		Help: "Number of failed queries.",
+	}, labels))
+	if err != nil {
+		return nil, err
+	}
+	rows, err := register(registerer, prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "db_rows_affected_total",
+		Help: "Number of rows affected or returned by queries.",
+	}, labels))
+	if err != nil {
+		return nil, err
+	}
+	return &Metrics{
+		queries:  queries.(*prometheus.CounterVec),
+		duration: duration.(*prometheus.HistogramVec),
+		errors:   failures.(*prometheus.CounterVec),
+		rows:     rows.(*prometheus.CounterVec),
+	}, nil
+}
+
+func register(registerer prometheus.Registerer, collector prometheus.Collector) (prometheus.Collector, error) {
+	if err := registerer.Register(collector); err != nil {
+		var registered prometheus.AlreadyRegisteredError
+		if errors.As(err, &registered) {
+			return registered.ExistingCollector, nil
+		}
+		return nil, err
+	}
+	return collector, nil
+}
+
+// Times the queries of a connection, recording them in the metrics and
+// logging the slow ones.
+type instrument struct {
+	connection    string
+	metrics       *Metrics
+	slow          *zap.Logger
+	slowThreshold time.Duration
+}
+
+func (m *Manager) instrument(name string, conf *ConnectionConfig, conn *gorm.DB) error {
+	base := m.logger
+	if base == nil {
+		base = log.Default()
+	}
+	i := &instrument{
+		connection:    name,
+		metrics:       m.metrics,
+		slow:          base.Channel(SlowQueryChannel).Logger.With(zap.String("connection", name)),
+		slowThreshold: time.Duration(conf.SlowThreshold) * time.Millisecond,
+	}
+	callbacks := conn.Callback()
+	for _, err := range []error{
+		callbacks.Create().Before("gorm:create").Register("service:metrics_start", start),
+		callbacks.Create().After("gorm:create").Register("service:metrics", i.finish("create")),
+		callbacks.Query().Before("gorm:query").Register("service:metrics_start", start),
+		callbacks.Query().After("gorm:query").Register("service:metrics", i.finish("query")),
+		callbacks.Update().Before("gorm:update").Register("service:metrics_start", start),
+		callbacks.Update().After("gorm:update").Register("service:metrics", i.finish("update")),
+		callbacks.Delete().Before("gorm:delete").Register("service:metrics_start", start),
+		callbacks.Delete().After("gorm:delete").Register("service:metrics", i.finish("delete")),
+		callbacks.Row().Before("gorm:row").Register("service:metrics_start", start),
+		callbacks.Row().After("gorm:row").Register("service:metrics", i.finish("row")),
+		callbacks.Raw().Before("gorm:raw").Register("service:metrics_start", start),
+		callbacks.Raw().After("gorm:raw").Register("service:metrics", i.finish("raw")),
+	} {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func start(db *gorm.DB) {
+	db.InstanceSet(startedAtKey, time.Now())
+}
+
+func (i *instrument) finish(operation string) func(*gorm.DB) {
+	return func(db *gorm.DB) {
+		value, ok := db.InstanceGet(startedAtKey)
+		if !ok {
+			return
+		}
+		elapsed := time.Since(value.(time.Time))
+		failed := db.Error != nil && !errors.Is(db.Error, gorm.ErrRecordNotFound)
+		if i.metrics != nil {
+			labels := prometheus.Labels{"connection": i.connection, "operation": operation, "table": db.Statement.Table}
+			i.metrics.queries.With(labels).Inc()
+			i.metrics.duration.With(labels).Observe(elapsed.Seconds())
+			if failed {
+				i.metrics.errors.With(labels).Inc()
+			} else if db.RowsAffected > 0 {
+				i.metrics.rows.With(labels).Add(float64(db.RowsAffected))
+			}
+		}
+		if failed || i.slowThreshold <= 0 || elapsed < i.slowThreshold {
+			return
+		}
+		query := db.Statement.SQL.String()
+		fields := []zap.Field{
+			zap.String("operation", operation),
+			zap.String("table", db.Statement.Table),
+			zap.String("sql", query),
+			zap.Duration("duration", elapsed),
+			zap.Int64("rows", db.RowsAffected),
+			zap.String("caller", caller()),
+		}
+		// The connection is still busy reading the rows of the row processor.
+		if operation != "row" {
+			if plan, err := explain(db, query); err != nil {
+				fields = append(fields, zap.NamedError("explain_error", err))
+			} else if plan != "" {
+				fields = append(fields, zap.String("explain", plan))
+			}
+		}
+		i.slow.Warn("slow query", fields...)
+	}
+}
+
+// Get the query plan of a statement on mysql and postgres, empty on the other
+// dialects.
+func explain(db *gorm.DB, query string) (string, error) {
+	if dialect := db.Dialector.Name(); dialect != DriverMysql && dialect != DriverPostgres {
+		return "", nil
+	}
+	fields := strings.Fields(query)
+	if len(fields) == 0 || !explainKeywords[strings.ToLower(fields[0])] {
+		return "", nil
+	}
+	rows, err := db.Statement.ConnPool.QueryContext(db.Statement.Context, "EXPLAIN "+query, db.Statement.Vars...)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+	lines, err := tabulate(rows)
+	return strings.Join(lines, "\n"), err
+}
+
+// Read rows into tab separated lines, the first one holding the column names.
+func tabulate(rows *sql.Rows) ([]string, error) {
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	lines := []string{strings.Join(columns, "\t")}
+	values := make([]interface{}, len(columns))
+	for i := range values {
+		values[i] = new(interface{})
+	}
+	for rows.Next() {
+		if err = rows.Scan(values...); err != nil {
+			return nil, err
+		}
+		cells := make([]string, len(values))
+		for i, value := range values {
+			cells[i] = formatCell(*value.(*interface{}))
+		}
+		lines = append(lines, strings.Join(cells, "\t"))
+	}
+	return lines, rows.Err()
+}
@@ -0,0 +1,221 @@
+package migrate
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/urionz/cobra"
+	"github.com/urionz/color"
+	"github.com/urionz/goofy"
+	"github.com/urionz/service/db/schema"
+	"gorm.io/gorm"
+)
+
+// Get the file the schema of a connection is dumped to.
+func schemaDumpPath(workspace, connection string) string {
+	return path.Join(workspace, "databases", "schema", connection+".sql")
+}
+
+type DumpCommand struct {
+	database string
+	prune    bool
+}
+
+func (cmd *DumpCommand) Handle(app goofy.IApplication) *cobra.Command {
+	command := &cobra.Command{
+		Use:   "schema:dump",
+		Short: "导出数据库结构及迁移记录",
+		RunE: func(c *cobra.Command, args []string) error {
+			var manager Factory
+			if err := app.Resolve(&manager); err != nil {
+				return err
+			}
+			opts := &runOptions{database: cmd.database}
+			set, err := opts.resolve(manager)
+			if err != nil {
+				color.Errorln(err)
+				return err
+			}
+			file := schemaDumpPath(app.Workspace(), set.connection)
+			if err = dumpSchema(set.db, file); err != nil {
+				color.Errorln(err)
+				return err
+			}
+			color.Infoln("Schema dumped:", file)
+			if cmd.prune {
+				if err = pruneMigrations(set.db, path.Join(app.Workspace(), "databases", "migration")); err != nil {
+					color.Errorln(err)
+					return err
+				}
+			}
+			return nil
+		},
+	}
+
+	command.PersistentFlags().StringVar(&cmd.database, "database", "", "数据库连接，默认为 database.default")
+	command.PersistentFlags().BoolVar(&cmd.prune, "prune", false, "删除已记录在导出文件中的迁移文件")
+
+	return command
+}
+
+// Get the tables migrations are recorded in, every group's.
+func historyTables(db *gorm.DB) ([]string, error) {
+	tables, err := schema.Tables(db)
+	if err != nil {
+		return nil, err
+	}
+	var history []string
+	for _, table := range tables {
+		if table != lockTable && (table == GroupTable(DefaultGroup) || strings.HasPrefix(table, GroupTable(DefaultGroup)+"_")) {
+			history = append(history, table)
+		}
+	}
+	return history, nil
+}
+
+// Write the statements creating the schema of db, then the ones inserting the
+// rows of its migrations tables, to file.
+func dumpSchema(db *gorm.DB, file string) error {
+	statements, err := schema.Dump(db, lockTable)
+	if err != nil {
+		return err
+	}
+	tables, err := historyTables(db)
+	if err != nil {
+		return err
+	}
+	for _, table := range tables {
+		var migrations []*Model
+		if err = db.Table(table).Order("id").Find(&migrations).Error; err != nil {
+			return err
+		}
+		var quoted strings.Builder
+		db.Dialector.QuoteTo(&quoted, table)
+		for _, migration := range migrations {
+			statements = append(statements, fmt.Sprintf(
+				"INSERT INTO %s (id, migration, batch) VALUES (%d, %s, %d)",
+				quoted.String(), migration.Id, quoteString(migration.Migration), migration.Batch,
+			))
+		}
+		// The ids are given explicitly, postgres doesn't move the sequence on.
+		if len(migrations) > 0 && db.Dialector.Name() == "postgres" {
+			statements = append(statements, fmt.Sprintf(
+				"SELECT setval(pg_get_serial_sequence(%s, 'id'), MAX(id)) FROM %s",
+				quoteString(quoted.String()), quoted.String(),
+			))
+		}
+	}
+	var content strings.Builder
+	content.WriteString("-- Schema and migrations dumped by schema:dump, loaded by migrate on an empty database.\n\n")
+	for _, statement := range statements {
+		content.WriteString(strings.TrimSuffix(strings.TrimSpace(statement), ";") + ";\n\n")
+	}
+	if err = os.MkdirAll(path.Dir(file), os.ModePerm); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(file, []byte(content.String()), 0644)
+}
+
+// Write s as a string literal. Dialects differ on double quotes and
+// backslashes, a doubled single quote is read the same by all of them.
+func quoteString(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// Load the schema dump into the database of set when it has no table yet, the
+// migrations it records are not run again. Nothing is done without a dump.
+func loadSchemaDump(set *migrationSet, file string) error {
+	tables, err := schema.Tables(set.db)
+	if err != nil {
+		return err
+	}
+	for _, table := range tables {
+		if table != lockTable {
+			return nil
+		}
+	}
+	content, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	color.Infoln("Loading schema dump:", file)
+	// A single connection runs every statement, session settings included.
+	return set.db.Transaction(func(tx *gorm.DB) error {
+		return execSQL(tx, string(content))
+	})
+}
+
+// Delete the files of dir holding registered migrations recorded in db, they
+// are part of its dump. The files are listed before any is deleted.
+func pruneMigrations(db *gorm.DB, dir string) error {
+	dumped := make(map[string]File)
+	for group, files := range migrationFiles {
+		table := GroupTable(group)
+		if !db.Migrator().HasTable(table) {
+			continue
+		}
+		var names []string
+		if err := db.Table(table).Pluck("migration", &names).Error; err != nil {
+			return err
+		}
+		ran := make(map[string]bool)
+		for _, name := range names {
+			ran[name] = true
+		}
+		for _, file := range files {
+			if ran[file.Name()] {
+				dumped[file.Name()] = file
+			}
+		}
+	}
+	infos, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var pruned []string
+	for _, info := range infos {
+		if !info.IsDir() && prunable(info.Name(), dumped) {
+			pruned = append(pruned, info.Name())
+		}
+	}
+	if len(pruned) == 0 {
+		return nil
+	}
+	color.Infoln("Pruning migrations recorded in the dump:", strings.Join(pruned, ", "))
+	for _, name := range pruned {
+		if err = os.Remove(path.Join(dir, name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Tell whether file holds one of the dumped migrations. Sql files are named
+// after theirs, make:migration names a go file after its migration without
+// the timestamp.
+func prunable(file string, dumped map[string]File) bool {
+	if matches := sqlFilePattern.FindStringSubmatch(file); matches != nil {
+		_, ok := dumped[matches[1]+"_"+matches[2]].(*SQLFile)
+		return ok
+	}
+	if !strings.HasSuffix(file, ".go") || strings.HasSuffix(file, "_test.go") {
+		return false
+	}
+	named := regexp.MustCompile(`^(\d+_)?` + regexp.QuoteMeta(strings.TrimSuffix(file, ".go")) + `$`)
+	for name, migration := range dumped {
+		if _, ok := migration.(*SQLFile); !ok && named.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,149 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// Table holding the lock on dialects without advisory locks.
+const lockTable = "migrations_lock"
+
+// Wait between two attempts at taking a lock held by someone else.
+var lockRetryInterval = 200 * time.Millisecond
+
+type lockRow struct {
+	Name       string `gorm:"primaryKey;size:191"`
+	Owner      string `gorm:"size:255"`
+	AcquiredAt time.Time
+}
+
+func (*lockRow) TableName() string {
+	return lockTable
+}
+
+// Take the lock guarding the migrations recorded in table, so a single
+// process migrates at a time. It waits up to timeout for the lock to be
+// released and returns the func releasing it.
+//
+// Mysql and postgres use advisory locks held by a dedicated connection, which
+// the server releases if the process dies. The other dialects insert a row in
+// the migrations_lock table, which has to be deleted by hand when a process
+// dies holding it.
+func acquireLock(db *gorm.DB, table string, timeout time.Duration) (func() error, error) {
+	switch db.Dialector.Name() {
+	case "mysql":
+		// Lock names are server wide, the database is part of it.
+		return advisoryLock(db, timeout, func(ctx context.Context, conn *sql.Conn) (bool, error) {
+			var locked sql.NullInt64
+			query, args := mysqlLock(table, timeout)
+			err := conn.QueryRowContext(ctx, query, args...).Scan(&locked)
+			return locked.Int64 == 1, err
+		}, mysqlUnlock, table)
+	case "postgres":
+		key := postgresLockKey(table)
+		return advisoryLock(db, timeout, func(ctx context.Context, conn *sql.Conn) (bool, error) {
+			deadline := time.Now().Add(timeout)
+			for {
+				var locked bool
+				if err := conn.QueryRowContext(ctx, postgresLock, key).Scan(&locked); err != nil || locked {
+					return locked, err
+				}
+				if time.Now().After(deadline) {
+					return false, nil
+				}
+				time.Sleep(lockRetryInterval)
+			}
+		}, postgresUnlock, key)
+	}
+	return tableLock(db, table, timeout)
+}
+
+const (
+	mysqlUnlock    = "SELECT RELEASE_LOCK(MD5(CONCAT(DATABASE(), '.', ?)))"
+	postgresLock   = "SELECT pg_try_advisory_lock($1)"
+	postgresUnlock = "SELECT pg_advisory_unlock($1)"
+)
+
+// Query taking the mysql lock on table. GET_LOCK waits whole seconds, timeout
+// is rounded up so a sub-second one still waits instead of failing at once.
+func mysqlLock(table string, timeout time.Duration) (string, []interface{}) {
+	seconds := int((timeout + time.Second - 1) / time.Second)
+	if seconds < 1 {
+		seconds = 1
+	}
+	return "SELECT GET_LOCK(MD5(CONCAT(DATABASE(), '.', ?)), ?)", []interface{}{table, seconds}
+}
+
+// Key of the postgres advisory lock on table, the same in every process.
+func postgresLockKey(table string) int64 {
+	return int64(crc32.ChecksumIEEE([]byte("migrate:" + table)))
+}
+
+func advisoryLock(
+	db *gorm.DB, timeout time.Duration, lock func(context.Context, *sql.Conn) (bool, error), unlock string, args ...interface{},
+) (func() error, error) {
+	pool, err := db.DB()
+	if err != nil {
+		return nil, err
+	}
+	ctx := context.Background()
+	conn, err := pool.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	locked, err := lock(ctx, conn)
+	if err == nil && !locked {
+		err = fmt.Errorf("timed out after %s waiting for another process to finish migrating", timeout)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return func() error {
+		defer conn.Close()
+		_, err := conn.ExecContext(ctx, unlock, args...)
+		return err
+	}, nil
+}
+
+func tableLock(db *gorm.DB, table string, timeout time.Duration) (func() error, error) {
+	// Inserting a held lock fails on every attempt, don't log each of them.
+	db = db.Session(&gorm.Session{Logger: db.Logger.LogMode(logger.Silent)})
+	if !db.Migrator().HasTable(lockTable) {
+		if err := db.Migrator().CreateTable(new(lockRow)); err != nil {
+			return nil, err
+		}
+	}
+	host, _ := os.Hostname()
+	row := &lockRow{Name: table, Owner: fmt.Sprintf("%s:%d", host, os.Getpid())}
+	deadline := time.Now().Add(timeout)
+	for {
+		row.AcquiredAt = time.Now()
+		err := db.Create(row).Error
+		if err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			var holder lockRow
+			if db.Where("name = ?", table).Take(&holder).Error != nil {
+				return nil, err
+			}
+			return nil, fmt.Errorf(
+				"timed out after %s waiting for %s, which has been migrating since %s; "+
+					"delete its row from %s if it is no longer running",
+				timeout, holder.Owner, holder.AcquiredAt.Format(time.RFC3339), lockTable,
+			)
+		}
+		time.Sleep(lockRetryInterval)
+	}
+	return func() error {
+		return db.Where("name = ? AND owner = ?", row.Name, row.Owner).Delete(new(lockRow)).Error
+	}, nil
+}
@@ -0,0 +1,28 @@
+package migrate
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMysqlLock(t *testing.T) {
+	for timeout, seconds := range map[time.Duration]int{
+		0:                       1,
+		500 * time.Millisecond:  1,
+		time.Second:             1,
+		1500 * time.Millisecond: 2,
+		time.Minute:             60,
+	} {
+		query, args := mysqlLock("migrations", timeout)
+		require.Equal(t, "SELECT GET_LOCK(MD5(CONCAT(DATABASE(), '.', ?)), ?)", query)
+		require.Equal(t, []interface{}{"migrations", seconds}, args, timeout)
+	}
+}
+
+func TestPostgresLockKey(t *testing.T) {
+	key := postgresLockKey("migrations")
+	require.Equal(t, key, postgresLockKey("migrations"))
+	require.NotEqual(t, key, postgresLockKey("billing_migrations"))
+}
@@ -1,21 +1,34 @@
 package migrate
 
-import "gorm.io/gorm"
+import (
+	"fmt"
+
+	"gorm.io/gorm"
+)
 
 type Model struct {
 	Id        int
 	Migration string
 	Batch     int
 	*gorm.DB  `gorm:"-"`
+	// Table the migrations are recorded in, migrations when empty.
+	table string
 }
 
 func (*Model) TableName() string {
 	return "migrations"
 }
 
+func (model *Model) query() *gorm.DB {
+	if model.table == "" {
+		return model.Model(model)
+	}
+	return model.Table(model.table)
+}
+
 func (model *Model) GetRan() ([]*Model, error) {
 	var migrations []*Model
-	if err := model.Model(model).
+	if err := model.query().
 		Order("batch asc").
 		Order("migration asc").
 		Find(&migrations).Error; err != nil {
@@ -24,13 +37,20 @@ func (model *Model) GetRan() ([]*Model, error) {
 	return migrations, nil
 }
 
+// Query the migrations latest first, the order they are rolled back in. The
+// id follows the order they ran in within a batch.
+func (model *Model) latest() *gorm.DB {
+	return model.query().Order("batch desc").Order("id desc")
+}
+
+// Get the last step migrations ran, every one of them when step is 0.
 func (model *Model) GetMigrations(step int) ([]*Model, error) {
 	var migrations []*Model
-	if err := model.Model(model).
-		Where("batch >= ?", 1).
-		Where("batch desc").
-		Where("migration desc").
-		Limit(step).Find(&migrations).Error; err != nil {
+	query := model.latest().Where("batch >= ?", 1)
+	if step > 0 {
+		query = query.Limit(step)
+	}
+	if err := query.Find(&migrations).Error; err != nil {
 		return migrations, err
 	}
 	return migrations, nil
@@ -42,19 +62,44 @@ func (model *Model) GetLast() ([]*Model, error) {
 	if err != nil {
 		return migrations, err
 	}
-	if err := model.Model(model).
-		Where("batch = ?", maxBatch).
-		Order("migration desc").
+	return model.GetBatch(maxBatch)
+}
+
+// Get the migrations of a batch, latest first.
+func (model *Model) GetBatch(batch int) ([]*Model, error) {
+	var migrations []*Model
+	if err := model.latest().
+		Where("batch = ?", batch).
+		Find(&migrations).
+		Error; err != nil {
+		return migrations, err
+	}
+	return migrations, nil
+}
+
+// Get the migrations ran after the named one, latest first.
+func (model *Model) GetAfter(name string) ([]*Model, error) {
+	var target Model
+	if err := model.query().Where("migration = ?", name).Take(&target).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, fmt.Errorf("migration %s has not been run", name)
+		}
+		return nil, err
+	}
+	var migrations []*Model
+	if err := model.latest().
+		Where("batch > ? OR (batch = ? AND id > ?)", target.Batch, target.Batch, target.Id).
 		Find(&migrations).
 		Error; err != nil {
 		return migrations, err
 	}
 	return migrations, nil
 }
+
 func (model *Model) GetMigrationBatches() (map[string]int, error) {
 	var migrations []*Model
 	batchesMap := make(map[string]int)
-	if err := model.Model(model).
+	if err := model.query().
 		Order("batch asc").
 		Order("migration asc").
 		Find(&migrations).Error; err != nil {
@@ -70,11 +115,11 @@ func (model *Model) Log(migrationName string, batch int) error {
 	var migration Model
 	migration.Migration = migrationName
 	migration.Batch = batch
-	return model.Create(&migration).Error
+	return model.query().Create(&migration).Error
 }
 
 func (model *Model) Delete(target *Model) error {
-	return model.DB.Delete(&target).Error
+	return model.query().Where("id = ?", target.Id).Delete(&Model{}).Error
 }
 
 func (model *Model) GetNextBatchNumber() (int, error) {
@@ -87,8 +132,8 @@ func (model *Model) GetNextBatchNumber() (int, error) {
 
 func (model *Model) GetLastBatchNumber() (int, error) {
 	var maxBatch int
-	if err := model.Model(model).
-		Select("IFNULL(MAX(batch), 0) max_match").
+	if err := model.query().
+		Select("COALESCE(MAX(batch), 0) AS max_match").
 		Pluck("max_match", &maxBatch).
 		Error; err != nil {
 		return maxBatch, err
@@ -97,9 +142,19 @@ func (model *Model) GetLastBatchNumber() (int, error) {
 }
 
 func NewDBMigration(db *gorm.DB) *Model {
-	var migration Model
-	if !db.Migrator().HasTable(&migration) {
-		db.Migrator().CreateTable(&migration)
+	return NewDBMigrationTable(db, "")
+}
+
+// Get the repository of the migrations recorded in table, creating it when
+// missing.
+func NewDBMigrationTable(db *gorm.DB, table string) *Model {
+	migration := Model{table: table}
+	if table == "" || table == migration.TableName() {
+		if !db.Migrator().HasTable(&migration) {
+			db.Migrator().CreateTable(&migration)
+		}
+	} else if !db.Migrator().HasTable(table) {
+		db.Table(table).Migrator().CreateTable(&migration)
 	}
 	migration.DB = db
 	return &migration
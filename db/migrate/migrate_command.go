@@ -3,13 +3,16 @@ package migrate
 import (
 	"bytes"
 	"errors"
+	"fmt"
 	"html/template"
+	"io"
+	"net/http"
 	"os"
 	"path"
 	"reflect"
 	"sort"
-	"strconv"
 	"strings"
+	"time"
 
 	"github.com/AlecAivazis/survey/v2"
 	"github.com/golang-module/carbon"
@@ -22,14 +25,15 @@ import (
 	"github.com/urionz/goofy"
 	"github.com/urionz/goutil/fsutil"
 	"github.com/urionz/goutil/strutil"
+	"github.com/urionz/service/db/schema"
 	"gorm.io/gorm"
 )
 
 var createStub = `package migrations
 
 import (
-	"github.com/goofy/service/db/migrate"
-	"github.com/goofy/service/db/model"
+	"github.com/urionz/service/db/migrate"
+	"github.com/urionz/service/db/model"
 	"gorm.io/gorm"
 )
 
@@ -49,6 +53,10 @@ func (table *{{ .StructName }}) MigrateTimestamp() int {
 	return {{ .Timestamp }}
 }
 
+func (table *{{ .StructName }}) Name() string {
+	return "{{ .Timestamp }}_{{ .Name }}"
+}
+
 func (table *{{ .StructName }}) Up(db *gorm.DB) error {
 	if !db.Migrator().HasTable(table) {
 		return db.Migrator().CreateTable(table)
@@ -64,8 +72,8 @@ func (table *{{ .StructName }}) Down(db *gorm.DB) error {
 var blankStub = `package migrations
 
 import (
-	"github.com/urionz/goofy/db/migrate"
-	"github.com/urionz/goofy/db/model"
+	"github.com/urionz/service/db/migrate"
+	"github.com/urionz/service/db/model"
 	"gorm.io/gorm"
 )
 
@@ -81,6 +89,10 @@ func (table *{{ .StructName }}) MigrateTimestamp() int {
 	return {{ .Timestamp }}
 }
 
+func (table *{{ .StructName }}) Name() string {
+	return "{{ .Timestamp }}_{{ .Name }}"
+}
+
 func (table *{{ .StructName }}) TableName() string {
 	return "{{ .TableName }}"
 }
@@ -96,10 +108,139 @@ func (table *{{ .StructName }}) Down(db *gorm.DB) error {
 
 type Factory interface {
 	Connection(...string) *gorm.DB
+	ConnectionE(...string) (*gorm.DB, error)
+	DefaultName() string
+}
+
+// Transaction modes, each migration runs in its own transaction by default.
+const (
+	TransactionMigration = "migration"
+	TransactionBatch     = "batch"
+	TransactionNone      = "none"
+)
+
+// Options of a migrate, rollback or reset run.
+type runOptions struct {
+	database    string
+	group       string
+	path        string
+	step        int
+	batch       int
+	target      string
+	transaction string
+	pretend     bool
+	noLock      bool
+	lockTimeout time.Duration
+	out         io.Writer
+}
+
+func (opts *runOptions) validate() error {
+	if !groupPattern.MatchString(opts.group) {
+		return fmt.Errorf("invalid group %q, expected letters, digits and underscores", opts.group)
+	}
+	if opts.path != "" && opts.group == DefaultGroup {
+		return errors.New("migrations of --path are recorded in the table of their group, give them one with --group")
+	}
+	if opts.lockTimeout < 0 {
+		return fmt.Errorf("invalid lock timeout %s", opts.lockTimeout)
+	}
+	if opts.step < 0 || opts.batch < 0 {
+		return errors.New("step and batch can't be negative")
+	}
+	selections := 0
+	for _, set := range []bool{opts.step > 0, opts.batch > 0, opts.target != ""} {
+		if set {
+			selections++
+		}
+	}
+	if selections > 1 {
+		return errors.New("only one of step, batch and target can be given")
+	}
+	switch opts.transaction {
+	case TransactionMigration, TransactionBatch, TransactionNone:
+		return nil
+	}
+	return fmt.Errorf("invalid transaction mode %q, expected migration, batch or none", opts.transaction)
+}
+
+// The migrations a run works on, the connection and the table they are
+// recorded in.
+type migrationSet struct {
+	db         *gorm.DB
+	connection string
+	table      string
+	files      []File
+}
+
+// Get the repository of the set, creating its table when missing.
+func (set *migrationSet) repository() *Model {
+	return NewDBMigrationTable(set.db, set.table)
+}
+
+// Get the files of the group, or of the path when one is given, which are not
+// pinned to another connection than the selected one.
+func (opts *runOptions) resolve(manager Factory) (*migrationSet, error) {
+	db, err := manager.ConnectionE(opts.database)
+	if err != nil {
+		return nil, err
+	}
+	set := &migrationSet{db: db, connection: opts.database, table: GroupTable(opts.group)}
+	if set.connection == "" {
+		set.connection = manager.DefaultName()
+	}
+	files := getMigrationFiles(opts.group)
+	if opts.path != "" {
+		if files, err = LoadSQL(http.Dir(opts.path), "/"); err != nil {
+			return nil, err
+		}
+	}
+	for _, file := range files {
+		if pinned, ok := file.(Connectioner); ok && pinned.Connection() != set.connection {
+			continue
+		}
+		set.files = append(set.files, file)
+	}
+	return set, nil
+}
+
+// Take the lock of the migrations of set, unless it is disabled or nothing
+// is going to change, and get the func releasing it.
+func (opts *runOptions) lock(set *migrationSet) (func(), error) {
+	if opts.noLock || opts.pretend {
+		return func() {}, nil
+	}
+	release, err := acquireLock(set.db, set.table, opts.lockTimeout)
+	if err != nil {
+		return nil, err
+	}
+	return func() {
+		if err := release(); err != nil {
+			color.Warnln("Releasing the migration lock failed:", err)
+		}
+	}, nil
+}
+
+func setFlags(command *cobra.Command, opts *runOptions) {
+	command.PersistentFlags().StringVar(&opts.database, "database", "", "数据库连接，默认为 database.default")
+	command.PersistentFlags().StringVar(&opts.group, "group", DefaultGroup,
+		"迁移分组，默认分组以外的分组记录在 migrations_<group> 表中")
+	command.PersistentFlags().StringVar(&opts.path, "path", "", "仅使用该目录中的 SQL 迁移，需同时指定 --group")
+}
+
+func runFlags(command *cobra.Command, opts *runOptions) {
+	setFlags(command, opts)
+	command.PersistentFlags().StringVar(&opts.transaction, "transaction", TransactionMigration,
+		"事务模式：migration 每个迁移一个事务，batch 整批一个事务，none 不使用事务")
+	lockFlags(command, opts)
+}
+
+func lockFlags(command *cobra.Command, opts *runOptions) {
+	command.PersistentFlags().BoolVar(&opts.noLock, "no-lock", false, "不获取迁移锁")
+	command.PersistentFlags().DurationVar(&opts.lockTimeout, "lock-timeout", time.Minute, "等待迁移锁的超时时间")
 }
 
 type Command struct {
-	step int
+	runOptions
 }
 
 func (cmd *Command) Handle(app goofy.IApplication) *cobra.Command {
@@ -111,20 +252,46 @@ func (cmd *Command) Handle(app goofy.IApplication) *cobra.Command {
 			if err := app.Resolve(&manager); err != nil {
 				return err
 			}
-			if err := runMigrate(cmd.step, manager); err != nil {
+			if err := cmd.validate(); err != nil {
+				color.Errorln(err)
+				return err
+			}
+			cmd.out = c.OutOrStdout()
+			set, err := cmd.resolve(manager)
+			if err != nil {
+				color.Errorln(err)
+				return err
+			}
+			release, err := cmd.lock(set)
+			if err != nil {
+				color.Errorln(err)
+				return err
+			}
+			defer release()
+			// The dump holds the default group only, --path and --group run apart from it.
+			if !cmd.pretend && cmd.group == DefaultGroup && cmd.path == "" {
+				if err = loadSchemaDump(set, schemaDumpPath(app.Workspace(), set.connection)); err != nil {
+					color.Errorln(err)
+					return err
+				}
+			}
+			if err := runMigrate(&cmd.runOptions, set); err != nil {
 				color.Errorln(err)
+				return err
 			}
 			return nil
 		},
 	}
 
-	command.PersistentFlags().IntVarP(&cmd.step, "step", "s", 0, "指定迁移阶段")
+	command.PersistentFlags().IntVarP(&cmd.step, "step", "s", 0, "仅运行接下来的 N 个迁移")
+	command.PersistentFlags().BoolVar(&cmd.pretend, "pretend", false, "仅输出将要执行的 SQL")
+	runFlags(command, &cmd.runOptions)
 
 	return command
 }
 
 type RollbackCommand struct {
-	step int
+	runOptions
 }
 
 func (cmd *RollbackCommand) Handle(app goofy.IApplication) *cobra.Command {
@@ -136,20 +303,39 @@ func (cmd *RollbackCommand) Handle(app goofy.IApplication) *cobra.Command {
 			if err := app.Resolve(&manager); err != nil {
 				return err
 			}
-			if err := runRollback(cmd.step, manager); err != nil {
+			if err := cmd.validate(); err != nil {
 				color.Errorln(err)
+				return err
+			}
+			set, err := cmd.resolve(manager)
+			if err != nil {
+				color.Errorln(err)
+				return err
+			}
+			release, err := cmd.lock(set)
+			if err != nil {
+				color.Errorln(err)
+				return err
+			}
+			defer release()
+			if err := runRollback(&cmd.runOptions, set); err != nil {
+				color.Errorln(err)
+				return err
 			}
 			return nil
 		},
 	}
 
-	command.PersistentFlags().IntVarP(&cmd.step, "step", "s", 0, "指定迁移阶段")
+	command.PersistentFlags().IntVarP(&cmd.step, "step", "s", 0, "回滚最近的 N 个迁移")
+	command.PersistentFlags().IntVar(&cmd.batch, "batch", 0, "回滚指定批次的迁移")
+	command.PersistentFlags().StringVar(&cmd.target, "target", "", "回滚至指定迁移，该迁移本身保留")
+	runFlags(command, &cmd.runOptions)
 
 	return command
 }
 
 type RefreshCommand struct {
-	step int
+	runOptions
 }
 
 func (cmd *RefreshCommand) Handle(app goofy.IApplication) *cobra.Command {
@@ -161,12 +347,26 @@ func (cmd *RefreshCommand) Handle(app goofy.IApplication) *cobra.Command {
 			if err := app.Resolve(&manager); err != nil {
 				return err
 			}
-			var err error
+			if err := cmd.validate(); err != nil {
+				color.Errorln(err)
+				return err
+			}
+			set, err := cmd.resolve(manager)
+			if err != nil {
+				color.Errorln(err)
+				return err
+			}
+			release, err := cmd.lock(set)
+			if err != nil {
+				color.Errorln(err)
+				return err
+			}
+			defer release()
 
 			if cmd.step > 0 {
-				err = runRollback(cmd.step, manager)
+				err = runRollback(&cmd.runOptions, set)
 			} else {
-				err = runReset(manager)
+				err = runReset(&cmd.runOptions, set)
 			}
 
 			if err != nil {
@@ -174,7 +374,10 @@ func (cmd *RefreshCommand) Handle(app goofy.IApplication) *cobra.Command {
 				return err
 			}
 
-			if err = runMigrate(cmd.step, manager); err != nil {
+			// Everything pending is migrated again, not only what was rolled back.
+			migrateOptions := cmd.runOptions
+			migrateOptions.step = 0
+			if err = runMigrate(&migrateOptions, set); err != nil {
 				color.Errorln(err)
 				return err
 			}
@@ -182,13 +385,14 @@ func (cmd *RefreshCommand) Handle(app goofy.IApplication) *cobra.Command {
 		},
 	}
 
-	command.PersistentFlags().IntVarP(&cmd.step, "step", "s", 0, "指定迁移阶段")
+	command.PersistentFlags().IntVarP(&cmd.step, "step", "s", 0, "回滚最近的 N 个迁移后重新运行")
+	runFlags(command, &cmd.runOptions)
 
 	return command
 }
 
 type FreshCommand struct {
-	step int
+	runOptions
 }
 
 func (cmd *FreshCommand) Handle(app goofy.IApplication) *cobra.Command {
@@ -200,21 +404,41 @@ func (cmd *FreshCommand) Handle(app goofy.IApplication) *cobra.Command {
 			if err := app.Resolve(&manager); err != nil {
 				return err
 			}
-			var tables []string
-			db := manager.Connection()
-			if err := db.Raw("show tables").Scan(&tables).Error; err != nil {
+			if err := cmd.validate(); err != nil {
+				color.Errorln(err)
 				return err
 			}
-
-			for _, table := range tables {
-				if err := db.Migrator().DropTable(table); err != nil {
-					return err
-				}
+			// Every table is dropped, whatever group created it.
+			if cmd.group != DefaultGroup {
+				err := fmt.Errorf("migrate:fresh drops every table, run migrate:refresh --group=%s to redo a group", cmd.group)
+				color.Errorln(err)
+				return err
+			}
+			set, err := cmd.resolve(manager)
+			if err != nil {
+				color.Errorln(err)
+				return err
+			}
+			release, err := cmd.lock(set)
+			if err != nil {
+				color.Errorln(err)
+				return err
+			}
+			defer release()
+			// Keep the lock table, the lock is held until migrating is done.
+			if _, _, err := schema.Wipe(set.db, false, lockTable); err != nil {
+				color.Errorln(err)
+				return err
 			}
 
 			color.Infoln("Dropped all tables successfully.")
 
-			if err := runMigrate(cmd.step, manager); err != nil {
+			if err = loadSchemaDump(set, schemaDumpPath(app.Workspace(), set.connection)); err != nil {
+				color.Errorln(err)
+				return err
+			}
+
+			if err := runMigrate(&cmd.runOptions, set); err != nil {
 				color.Errorln(err)
 				return err
 			}
@@ -222,67 +446,105 @@ func (cmd *FreshCommand) Handle(app goofy.IApplication) *cobra.Command {
 		},
 	}
 
-	command.PersistentFlags().IntVarP(&cmd.step, "step", "s", 0, "指定迁移阶段")
+	command.PersistentFlags().IntVarP(&cmd.step, "step", "s", 0, "仅运行接下来的 N 个迁移")
+	runFlags(command, &cmd.runOptions)
 
 	return command
 }
 
 type StatusCommand struct {
+	runOptions
 }
 
 func (cmd *StatusCommand) Handle(app goofy.IApplication) *cobra.Command {
-	var db *gorm.DB
 	command := &cobra.Command{
 		Use:   "migrate:status",
 		Short: "查看迁移状态",
 		RunE: func(c *cobra.Command, args []string) error {
-			if err := app.Resolve(&db); err != nil {
+			var manager Factory
+			if err := app.Resolve(&manager); err != nil {
+				return err
+			}
+			if !groupPattern.MatchString(cmd.group) {
+				err := fmt.Errorf("invalid group %q, expected letters, digits and underscores", cmd.group)
+				color.Errorln(err)
 				return err
 			}
-			var batches map[string]int
-			var ran []*Model
-			var err error
-			repository := NewDBMigration(db)
-			ran, err = repository.GetRan()
+			set, err := cmd.resolve(manager)
 			if err != nil {
 				color.Errorln(err)
-				return nil
+				return err
 			}
-			ranNameCollection := collection.NewObjPointCollection(ran).Pluck("Migration")
-
-			batches, err = repository.GetMigrationBatches()
+			batches, err := set.repository().GetMigrationBatches()
 			if err != nil {
 				color.Errorln(err)
 				return err
 			}
-			table := show.NewTable("migrate status")
-			table.Cols = []string{"Ran?", "Migration", "Batch"}
-			for _, migrateFile := range getMigrationFiles() {
-				migrationName := getMigrationName(migrateFile)
-				if ranNameCollection.Contains(migrationName) {
-					table.Cols = []string{
-						color.String("<green>Yes</>"),
-						migrationName,
-						strconv.Itoa(batches[migrationName]),
-					}
+			files := append([]File(nil), set.files...)
+			sortFileMigrations(files)
+			rows := []string{"Ran?\tMigration\tBatch"}
+			for _, migrateFile := range files {
+				migrationName := migrateFile.Name()
+				if batch, ok := batches[migrationName]; ok {
+					rows = append(rows, fmt.Sprintf("%s\t%s\t%d", color.String("<green>Yes</>"), migrationName, batch))
 				} else {
-					table.Cols = []string{
-						color.String("<red>No</>"),
-						migrationName,
-						"",
-					}
+					rows = append(rows, fmt.Sprintf("%s\t%s\t", color.String("<red>No</>"), migrationName))
 				}
 			}
-			table.SetOutput(os.Stdout)
-			table.Println()
+			return show.TabWriter(c.OutOrStdout(), rows).Flush()
+		},
+	}
+
+	setFlags(command, &cmd.runOptions)
+
+	return command
+}
+
+type RenameLegacyCommand struct {
+	runOptions
+}
+
+func (cmd *RenameLegacyCommand) Handle(app goofy.IApplication) *cobra.Command {
+	command := &cobra.Command{
+		Use:   "migrate:rename-legacy",
+		Short: "将按类型名记录的旧迁移重命名",
+		RunE: func(c *cobra.Command, args []string) error {
+			var manager Factory
+			if err := app.Resolve(&manager); err != nil {
+				return err
+			}
+			if !groupPattern.MatchString(cmd.group) {
+				err := fmt.Errorf("invalid group %q, expected letters, digits and underscores", cmd.group)
+				color.Errorln(err)
+				return err
+			}
+			set, err := cmd.resolve(manager)
+			if err != nil {
+				color.Errorln(err)
+				return err
+			}
+			release, err := cmd.lock(set)
+			if err != nil {
+				color.Errorln(err)
+				return err
+			}
+			defer release()
+			if err := renameLegacyMigrations(set); err != nil {
+				color.Errorln(err)
+				return err
+			}
 			return nil
 		},
 	}
 
+	setFlags(command, &cmd.runOptions)
+	lockFlags(command, &cmd.runOptions)
+
 	return command
 }
 
 type ResetCommand struct {
+	runOptions
 }
 
 func (cmd *ResetCommand) Handle(app goofy.IApplication) *cobra.Command {
@@ -294,7 +556,22 @@ func (cmd *ResetCommand) Handle(app goofy.IApplication) *cobra.Command {
 			if err := app.Resolve(&manager); err != nil {
 				return err
 			}
-			if err := runReset(manager); err != nil {
+			if err := cmd.validate(); err != nil {
+				color.Errorln(err)
+				return err
+			}
+			set, err := cmd.resolve(manager)
+			if err != nil {
+				color.Errorln(err)
+				return err
+			}
+			release, err := cmd.lock(set)
+			if err != nil {
+				color.Errorln(err)
+				return err
+			}
+			defer release()
+			if err := runReset(&cmd.runOptions, set); err != nil {
 				color.Errorln(err)
 				return err
 			}
@@ -302,6 +579,8 @@ func (cmd *ResetCommand) Handle(app goofy.IApplication) *cobra.Command {
 		},
 	}
 
+	runFlags(command, &cmd.runOptions)
+
 	return command
 }
 
@@ -386,7 +665,7 @@ func writeMigration(name, table, generatePath string, isCreate bool) error {
 		}
 	}
 
-	stubString, err := populateStub(stub, table)
+	stubString, err := populateStub(stub, name, table)
 
 	if err != nil {
 		return err
@@ -401,7 +680,17 @@ func writeMigration(name, table, generatePath string, isCreate bool) error {
 	return nil
 }
 
-func populateStub(stub, table string) (string, error) {
+// Name the struct of a migration after it, prefixed when the name starts with
+// a digit so it stays a valid identifier.
+func migrationStructName(name string) string {
+	structName := strutil.ToCamel(name)
+	if structName == "" || (structName[0] >= '0' && structName[0] <= '9') {
+		structName = "Migration" + structName
+	}
+	return structName
+}
+
+func populateStub(stub, name, table string) (string, error) {
 	var templateBuffer bytes.Buffer
 	tpl, err := template.New("migration").Parse(stub)
 	if err != nil {
@@ -409,7 +698,8 @@ func populateStub(stub, table string) (string, error) {
 	}
 
 	if err := tpl.ExecuteTemplate(&templateBuffer, "migration", map[string]interface{}{
-		"StructName": strings.ToUpper(strutil.RandomChars(6)),
+		"StructName": migrationStructName(name),
+		"Name":       name,
 		"TableName":  table,
 		"Timestamp":  carbon.Now().ToTimestamp(),
 	}); err != nil {
@@ -419,14 +709,59 @@ func populateStub(stub, table string) (string, error) {
 	return templateBuffer.String(), nil
 }
 
-func runPending(migrations []File, step int, db *gorm.DB) error {
+// Run fn for every one of count migrations, each in its own transaction or
+// all of them in a single one depending on the transaction mode. Mysql commits
+// DDL statements implicitly, transactions can't undo them there.
+func (opts *runOptions) each(db *gorm.DB, count int, fn func(tx *gorm.DB, i int) error) error {
+	transactional := db.Dialector.Name() != "mysql"
+	switch {
+	case opts.transaction == TransactionBatch && transactional:
+		return db.Transaction(func(tx *gorm.DB) error {
+			for i := 0; i < count; i++ {
+				if err := fn(tx, i); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	case opts.transaction == TransactionBatch:
+		color.Warnln(db.Dialector.Name(), "does not support transactional DDL, migrations run without a transaction.")
+	case opts.transaction == TransactionMigration && transactional:
+		for i := 0; i < count; i++ {
+			if err := db.Transaction(func(tx *gorm.DB) error {
+				return fn(tx, i)
+			}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for i := 0; i < count; i++ {
+		if err := fn(db, i); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func runPending(migrations []File, opts *runOptions, set *migrationSet) error {
+	db := set.db
 	repository := &Model{
-		DB: db,
+		DB:    db,
+		table: set.table,
 	}
 
 	if len(migrations) == 0 {
-		err := errors.New("nothing to migrate")
-		return err
+		color.Infoln("Nothing to migrate.")
+		return nil
+	}
+
+	if opts.step > 0 && opts.step < len(migrations) {
+		migrations = migrations[:opts.step]
+	}
+
+	if opts.pretend {
+		return pretend(migrations, db, opts.out)
 	}
 
 	batch, err := repository.GetNextBatchNumber()
@@ -438,16 +773,14 @@ func runPending(migrations []File, step int, db *gorm.DB) error {
 	p := progress.Bar(len(migrations))
 	p.Start()
 
-	for _, migrationFile := range migrations {
-
-		if err := runUp(migrationFile, batch, db); err != nil {
+	if err = opts.each(db, len(migrations), func(tx *gorm.DB, i int) error {
+		if err := runUp(migrations[i], batch, tx, set.table); err != nil {
 			return err
 		}
-
-		if step > 0 {
-			batch++
-		}
 		p.Advance()
+		return nil
+	}); err != nil {
+		return err
 	}
 
 	p.Finish()
@@ -455,11 +788,12 @@ func runPending(migrations []File, step int, db *gorm.DB) error {
 	return nil
 }
 
-func runUp(file File, batch int, db *gorm.DB) error {
+func runUp(file File, batch int, db *gorm.DB, table string) error {
 	repository := &Model{
-		DB: db,
+		DB:    db,
+		table: table,
 	}
-	name := getMigrationName(file)
+	name := file.Name()
 
 	color.Infoln("Migrating:", name)
 
@@ -475,58 +809,79 @@ func runUp(file File, batch int, db *gorm.DB) error {
 	return nil
 }
 
-func runRollback(step int, manager Factory) error {
-	repository := NewDBMigration(manager.Connection())
-	dbMigrations, err := getMigrationsForRollback(step, repository)
+func runRollback(opts *runOptions, set *migrationSet) error {
+	repository := set.repository()
+	dbMigrations, err := getMigrationsForRollback(opts, repository)
 
 	if err != nil {
 		return err
 	}
 
-	return rollbackMigrations(dbMigrations, manager.Connection())
+	if len(dbMigrations) == 0 {
+		color.Infoln("Nothing to rollback.")
+		return nil
+	}
+
+	return rollbackMigrations(dbMigrations, opts, set)
 }
 
-func runReset(manager Factory) error {
+func runReset(opts *runOptions, set *migrationSet) error {
 	var migrations []*Model
 	var err error
-	migrations, err = NewDBMigration(manager.Connection()).GetRan()
+	migrations, err = set.repository().GetMigrations(0)
 	if err != nil {
 		return err
 	}
 
-	if len(migrations) == 0 || len(getMigrationFiles()) == 0 {
+	if len(migrations) == 0 || len(set.files) == 0 {
 		err = errors.New("nothing to rollback")
 		return err
 	}
 
-	return rollbackMigrations(migrations, manager.Connection())
+	return rollbackMigrations(migrations, opts, set)
 }
 
-func runMigrate(step int, manager Factory) error {
+func runMigrate(opts *runOptions, set *migrationSet) error {
 	var ran []*Model
 	var err error
 
-	if ran, err = NewDBMigration(manager.Connection()).GetRan(); err != nil {
+	if !opts.pretend {
+		ran, err = set.repository().GetRan()
+	} else if set.db.Migrator().HasTable(set.table) {
+		// A dry run writes nothing, a missing table means nothing ran yet.
+		ran, err = (&Model{DB: set.db, table: set.table}).GetRan()
+	}
+	if err != nil {
 		return err
 	}
 
-	migrateFiles := getMigrationFiles()
+	migrateFiles := set.files
+
+	if legacy := getLegacyMigrations(migrateFiles, ran); len(legacy) > 0 {
+		return fmt.Errorf("migrations %s are recorded under their legacy name, run migrate:rename-legacy first",
+			strings.Join(legacy, ", "))
+	}
 
 	pendingMigrateFiles := getPendingMigrations(migrateFiles, ran)
 
 	sortFileMigrations(pendingMigrateFiles)
 
-	if err := runPending(pendingMigrateFiles, step, manager.Connection()); err != nil {
+	if err := runPending(pendingMigrateFiles, opts, set); err != nil {
 		return err
 	}
 	return nil
 }
 
-func getMigrationFiles() []File {
-	return migrationFiles
+func getMigrationFiles(group string) []File {
+	return migrationFiles[group]
 }
 
-func getMigrationName(migrateFile File) string {
+// Get the name files used to be recorded under, before they could be named.
+func getLegacyMigrationName(migrateFile File) string {
+	return typeSlug(migrateFile)
+}
+
+func typeSlug(migrateFile File) string {
 	migrationNames := strings.Split(reflect.TypeOf(migrateFile).String(), ".")
 	return strutil.ToSnake(migrationNames[len(migrationNames)-1])
 }
@@ -541,60 +896,99 @@ func getPendingMigrations(files []File, ran []*Model) []File {
 	var pendingMigrations []File
 	ranNameCollection := collection.NewObjPointCollection(ran).Pluck("Migration")
 	for _, migrateFile := range files {
-		if !ranNameCollection.Contains(getMigrationName(migrateFile)) {
+		if !ranNameCollection.Contains(migrateFile.Name()) {
 			pendingMigrations = append(pendingMigrations, migrateFile)
 		}
 	}
 	return pendingMigrations
 }
 
-func getMigrationsForRollback(step int, repository *Model) ([]*Model, error) {
-	var dbMigrates []*Model
-	var err error
-	if step > 0 {
-		dbMigrates, err = repository.GetMigrations(step)
-	} else {
-		dbMigrates, err = repository.GetLast()
+// Get the legacy names of the files still recorded under them, they would be
+// run again otherwise.
+func getLegacyMigrations(files []File, ran []*Model) []string {
+	var legacy []string
+	ranNameCollection := collection.NewObjPointCollection(ran).Pluck("Migration")
+	for _, migrateFile := range files {
+		name, legacyName := migrateFile.Name(), getLegacyMigrationName(migrateFile)
+		if name != legacyName && ranNameCollection.Contains(legacyName) && !ranNameCollection.Contains(name) {
+			legacy = append(legacy, legacyName)
+		}
+	}
+	return legacy
+}
+
+// Rewrite the rows of the files recorded under their legacy name, which has
+// to be done before their types are renamed.
+func renameLegacyMigrations(set *migrationSet) error {
+	ran, err := set.repository().GetRan()
+	if err != nil {
+		return err
+	}
+	legacy := getLegacyMigrations(set.files, ran)
+	if len(legacy) == 0 {
+		color.Infoln("Nothing to rename.")
+		return nil
+	}
+	return set.db.Transaction(func(tx *gorm.DB) error {
+		for _, migrateFile := range set.files {
+			name, legacyName := migrateFile.Name(), getLegacyMigrationName(migrateFile)
+			if !collection.NewStrCollection(legacy).Contains(legacyName) {
+				continue
+			}
+			if err := tx.Table(set.table).
+				Where("migration = ?", legacyName).
+				Update("migration", name).Error; err != nil {
+				return err
+			}
+			color.Infoln("Renamed:", legacyName, "=>", name)
+		}
+		return nil
+	})
+}
+
+func getMigrationsForRollback(opts *runOptions, repository *Model) ([]*Model, error) {
+	switch {
+	case opts.target != "":
+		return repository.GetAfter(opts.target)
+	case opts.batch > 0:
+		return repository.GetBatch(opts.batch)
+	case opts.step > 0:
+		return repository.GetMigrations(opts.step)
 	}
-	return dbMigrates, err
+	return repository.GetLast()
 }
 
-func rollbackMigrations(migrations []*Model, db *gorm.DB) error {
-	files := getMigrationFiles()
+func rollbackMigrations(migrations []*Model, opts *runOptions, set *migrationSet) error {
+	files := set.files
 
 	existsFileMigrates := func(dbMigrate *Model) (File, bool) {
 		for _, migrateFile := range files {
-			migrationNames := strings.Split(reflect.TypeOf(migrateFile).String(), ".")
-			migrationName := strutil.ToSnake(migrationNames[len(migrationNames)-1])
-			if dbMigrate.Migration == migrationName {
+			if dbMigrate.Migration == migrateFile.Name() {
 				return migrateFile, true
 			}
 		}
 		return nil, false
 	}
 
-	for _, migration := range migrations {
-		file, exists := existsFileMigrates(migration)
+	return opts.each(set.db, len(migrations), func(tx *gorm.DB, i int) error {
+		file, exists := existsFileMigrates(migrations[i])
 
 		if !exists {
-			color.Warnln("Migration not found:", migration.Migration)
-			continue
-		}
-
-		if err := runDown(file, migration, db); err != nil {
-			return err
+			color.Warnln("Migration not found:", migrations[i].Migration)
+			return nil
 		}
-	}
 
-	return nil
+		return runDown(file, migrations[i], tx, set.table)
+	})
 }
 
-func runDown(file File, migration *Model, db *gorm.DB) (err error) {
+func runDown(file File, migration *Model, db *gorm.DB, table string) (err error) {
 	repository := &Model{
-		DB: db,
+		DB:    db,
+		table: table,
 	}
 
-	name := getMigrationName(file)
+	name := file.Name()
 
 	color.Infoln("Rolling back:", name)
 
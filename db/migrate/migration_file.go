@@ -1,16 +1,66 @@
 package migrate
 
-import "gorm.io/gorm"
+import (
+	"fmt"
+	"regexp"
 
-var migrationFiles []File
+	"gorm.io/gorm"
+)
+
+// Group files are registered in by Register, recorded in the migrations
+// table.
+const DefaultGroup = "default"
+
+var groupPattern = regexp.MustCompile(`^\w+$`)
+
+// Files registered in each group.
+var migrationFiles = make(map[string][]File)
 
 type File interface {
+	// Name recorded in the migrations table, unique in the group of the file.
+	// It must not change once the file ran, make:migration gives
+	// <timestamp>_<name>.
+	Name() string
 	MigrateTimestamp() int
 	TableName() string
 	Up(db *gorm.DB) error
 	Down(db *gorm.DB) error
 }
 
+// Optionally implemented by files only to be run on the named connection, the
+// others run on whichever connection is migrated.
+type Connectioner interface {
+	Connection() string
+}
+
 func Register(migrateFile ...File) {
-	migrationFiles = append(migrationFiles, migrateFile...)
+	RegisterGroup(DefaultGroup, migrateFile...)
+}
+
+// Register files in a group, migrated apart from the other groups with
+// --group and recorded in a table of its own. It panics when a name is
+// registered twice in the group.
+func RegisterGroup(group string, migrateFile ...File) {
+	for _, file := range migrateFile {
+		for _, registered := range migrationFiles[group] {
+			if registered.Name() == file.Name() {
+				panic(fmt.Sprintf("migrate: migration %s registered twice in group %s", file.Name(), group))
+			}
+		}
+		migrationFiles[group] = append(migrationFiles[group], file)
+	}
+}
+
+// Forget the files registered in every group, so tests can register their own.
+func Clear() {
+	migrationFiles = make(map[string][]File)
+}
+
+// Get the table the migrations of a group are recorded in, migrations for the
+// default group and migrations_<group> for the others.
+func GroupTable(group string) string {
+	if group == "" || group == DefaultGroup {
+		return new(Model).TableName()
+	}
+	return new(Model).TableName() + "_" + group
 }
@@ -0,0 +1,100 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// Statements reading the database, run for real while pretending so
+// migrations can still inspect the schema.
+var readKeywords = map[string]bool{
+	"select": true,
+	"show":   true,
+	"pragma": true,
+}
+
+// Connection pool of a dry run, recording the statements which would change
+// the database instead of running them. Gorm's DryRun mode can't be used as
+// is, it leaves the rows of Migrator().HasTable and the like unset.
+//
+// It passes for a transaction so dbresolver keeps statements on it.
+type recorder struct {
+	pool       gorm.ConnPool
+	dialector  gorm.Dialector
+	statements []string
+}
+
+var _ gorm.TxCommitter = (*recorder)(nil)
+
+func (r *recorder) PrepareContext(ctx context.Context, query string) (*sql.Stmt, error) {
+	return nil, fmt.Errorf("prepared statements can't be pretended: %s", query)
+}
+
+func (r *recorder) ExecContext(_ context.Context, query string, args ...interface{}) (sql.Result, error) {
+	r.statements = append(r.statements, r.dialector.Explain(query, args...))
+	return driver.RowsAffected(0), nil
+}
+
+func (r *recorder) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	if !reads(query) {
+		r.statements = append(r.statements, r.dialector.Explain(query, args...))
+		return nil, fmt.Errorf("statements returning rows can't be pretended: %s", query)
+	}
+	return r.pool.QueryContext(ctx, query, args...)
+}
+
+func (r *recorder) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	if !reads(query) {
+		r.statements = append(r.statements, r.dialector.Explain(query, args...))
+		// A row can't be built by hand, a cancelled context gets one holding
+		// an error without the statement reaching the database.
+		cancelled, cancel := context.WithCancel(ctx)
+		cancel()
+		ctx = cancelled
+	}
+	return r.pool.QueryRowContext(ctx, query, args...)
+}
+
+func (r *recorder) Commit() error {
+	return nil
+}
+
+func (r *recorder) Rollback() error {
+	return nil
+}
+
+func reads(query string) bool {
+	fields := strings.Fields(query)
+	return len(fields) > 0 && readKeywords[strings.ToLower(fields[0])]
+}
+
+// Run Up of the files against a dry run session and print the sql they would
+// execute, nothing is changed nor logged in the migrations table.
+func pretend(files []File, db *gorm.DB, out io.Writer) error {
+	for _, file := range files {
+		r := &recorder{pool: db.Statement.ConnPool, dialector: db.Dialector}
+		ctx := db.Statement.Context
+		if ctx == nil {
+			ctx = context.Background()
+		}
+		// A session with a context gets its own statement, the pool of db is
+		// left alone.
+		session := db.WithContext(ctx)
+		session.Statement.ConnPool = r
+		err := file.Up(session)
+		fmt.Fprintf(out, "%s:\n", file.Name())
+		for _, statement := range r.statements {
+			fmt.Fprintf(out, "  %s;\n", strings.TrimSuffix(strings.TrimSpace(statement), ";"))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
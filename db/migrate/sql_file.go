@@ -0,0 +1,173 @@
+package migrate
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/urionz/service/db/schema"
+	"gorm.io/gorm"
+)
+
+// Name of sql migration files, <timestamp>_<name>.up.sql runs the migration
+// and the optional <timestamp>_<name>.down.sql rolls it back.
+var sqlFilePattern = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// Migration written in plain sql, recorded as <timestamp>_<name>.
+type SQLFile struct {
+	name      string
+	timestamp int
+	up        string
+	down      string
+	hasUp     bool
+	hasDown   bool
+}
+
+var _ File = (*SQLFile)(nil)
+
+func (file *SQLFile) Name() string {
+	return file.name
+}
+
+func (file *SQLFile) MigrateTimestamp() int {
+	return file.timestamp
+}
+
+func (file *SQLFile) TableName() string {
+	return ""
+}
+
+func (file *SQLFile) Up(db *gorm.DB) error {
+	return execSQL(db, file.up)
+}
+
+func (file *SQLFile) Down(db *gorm.DB) error {
+	if !file.hasDown {
+		return fmt.Errorf("migration %s has no down.sql, it can't be rolled back", file.name)
+	}
+	return execSQL(db, file.down)
+}
+
+// Run the statements of sql one by one, split the way the dialect of db
+// parses them.
+func execSQL(db *gorm.DB, sql string) error {
+	dialect := db.Dialector.Name()
+	statements, rest := schema.Split(dialect, sql)
+	if !onlyComments(dialect, rest) {
+		statements = append(statements, strings.TrimSpace(rest))
+	}
+	for _, statement := range statements {
+		if err := db.Exec(statement).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Whether sql holds nothing but whitespace, line and block comments.
+func onlyComments(dialect, sql string) bool {
+	for sql = strings.TrimSpace(sql); sql != ""; sql = strings.TrimSpace(sql) {
+		switch {
+		case strings.HasPrefix(sql, "--"), dialect == "mysql" && strings.HasPrefix(sql, "#"):
+			end := strings.IndexByte(sql, '\n')
+			if end < 0 {
+				return true
+			}
+			sql = sql[end+1:]
+		case strings.HasPrefix(sql, "/*"):
+			end := strings.Index(sql[2:], "*/")
+			if end < 0 {
+				return true
+			}
+			sql = sql[end+4:]
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// Register the sql migrations of dir in fsys, they are ordered with the other
+// files by timestamp. Files not named like a migration are ignored. An
+// embed.FS is registered through http.FS. Files of LoadSQL are registered in
+// another group with RegisterGroup.
+func RegisterSQL(fsys http.FileSystem, dir string) error {
+	files, err := LoadSQL(fsys, dir)
+	if err != nil {
+		return err
+	}
+	Register(files...)
+	return nil
+}
+
+// Register the sql migrations of a directory on disk.
+func RegisterSQLDir(dir string) error {
+	return RegisterSQL(http.Dir(dir), "/")
+}
+
+// Read the sql migrations of dir in fsys, ordered by name.
+func LoadSQL(fsys http.FileSystem, dir string) ([]File, error) {
+	root, err := fsys.Open(dir)
+	if err != nil {
+		return nil, err
+	}
+	defer root.Close()
+	infos, err := root.Readdir(-1)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Name() < infos[j].Name()
+	})
+	files := make(map[string]*SQLFile)
+	var names []string
+	for _, info := range infos {
+		matches := sqlFilePattern.FindStringSubmatch(info.Name())
+		if info.IsDir() || matches == nil {
+			continue
+		}
+		timestamp, err := strconv.Atoi(matches[1])
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", info.Name(), err)
+		}
+		content, err := readFile(fsys, path.Join(dir, info.Name()))
+		if err != nil {
+			return nil, err
+		}
+		name := matches[1] + "_" + matches[2]
+		file, ok := files[name]
+		if !ok {
+			file = &SQLFile{name: name, timestamp: timestamp}
+			files[name] = file
+			names = append(names, name)
+		}
+		if matches[3] == "up" {
+			file.up, file.hasUp = content, true
+		} else {
+			file.down, file.hasDown = content, true
+		}
+	}
+	loaded := make([]File, 0, len(names))
+	for _, name := range names {
+		if !files[name].hasUp {
+			return nil, fmt.Errorf("migration %s has a down.sql but no up.sql", name)
+		}
+		loaded = append(loaded, files[name])
+	}
+	return loaded, nil
+}
+
+func readFile(fsys http.FileSystem, name string) (string, error) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	content, err := ioutil.ReadAll(f)
+	return string(content), err
+}
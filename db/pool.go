@@ -0,0 +1,32 @@
+package db
+
+import (
+	"database/sql"
+	"time"
+
+	"gorm.io/plugin/dbresolver"
+)
+
+// Apply the pool settings to the primary pool of a connection.
+func (conf *ConnectionConfig) configurePool(pool *sql.DB) {
+	pool.SetMaxOpenConns(conf.MaxOpenConns)
+	pool.SetMaxIdleConns(conf.MaxIdleConns)
+	pool.SetConnMaxLifetime(conf.ConnMaxLifetime)
+	if conf.ConnMaxIdleTime > 0 {
+		// SetConnMaxIdleTime is only available since go 1.15.
+		if setter, ok := interface{}(pool).(interface{ SetConnMaxIdleTime(time.Duration) }); ok {
+			setter.SetConnMaxIdleTime(conf.ConnMaxIdleTime)
+		}
+	}
+}
+
+// Apply the pool settings to every read and write host pool.
+func (conf *ConnectionConfig) configureResolver(resolver *dbresolver.DBResolver) *dbresolver.DBResolver {
+	resolver.SetMaxOpenConns(conf.MaxOpenConns).
+		SetMaxIdleConns(conf.MaxIdleConns).
+		SetConnMaxLifetime(conf.ConnMaxLifetime)
+	if conf.ConnMaxIdleTime > 0 {
+		resolver.SetConnMaxIdleTime(conf.ConnMaxIdleTime)
+	}
+	return resolver
+}
@@ -0,0 +1,186 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"sync/atomic"
+
+	"gorm.io/gorm"
+	"gorm.io/plugin/dbresolver"
+)
+
+const (
+	PolicyRandom     = "random"
+	PolicyRoundRobin = "round_robin"
+)
+
+var (
+	// Force a statement onto the write hosts, e.g. Connection().Clauses(db.Write).
+	Write = dbresolver.Write
+	// Force a statement onto the read hosts.
+	Read = dbresolver.Read
+)
+
+type stickyKey struct{}
+
+// Sticky state shared by every statement run with the same context.
+type sticky struct {
+	written int32
+}
+
+// Sticky returns a context which, on connections configured with sticky,
+// sends reads to the write hosts once a write was made through it. Bind it to
+// a request so the request sees its own writes despite replication lag.
+func Sticky(ctx context.Context) context.Context {
+	if _, ok := ctx.Value(stickyKey{}).(*sticky); ok {
+		return ctx
+	}
+	return context.WithValue(ctx, stickyKey{}, new(sticky))
+}
+
+// Spread reads over the replicas in turn.
+type roundRobinPolicy struct {
+	next uint64
+}
+
+func (p *roundRobinPolicy) Resolve(pools []gorm.ConnPool) gorm.ConnPool {
+	return pools[(atomic.AddUint64(&p.next, 1)-1)%uint64(len(pools))]
+}
+
+func newPolicy(name string) dbresolver.Policy {
+	if name == PolicyRoundRobin {
+		return new(roundRobinPolicy)
+	}
+	return dbresolver.RandomPolicy{}
+}
+
+// Register dbresolver on conn when read or write hosts are configured and get
+// the pools it opened, the read replica pools coming last.
+func (conf *ConnectionConfig) registerResolver(conn *gorm.DB) ([]*sql.DB, error) {
+	if len(conf.Read) == 0 && len(conf.Write) == 0 {
+		return nil, nil
+	}
+	sources, err := conf.hostDialectors(conf.Write)
+	if err != nil {
+		return nil, err
+	}
+	replicas, err := conf.hostDialectors(conf.Read)
+	if err != nil {
+		return nil, err
+	}
+	var pools []*sql.DB
+	resolver := conf.configureResolver(dbresolver.Register(dbresolver.Config{
+		Sources:  sources,
+		Replicas: replicas,
+		Policy:   newPolicy(conf.Policy),
+	}))
+	resolver.Call(func(pool gorm.ConnPool) error {
+		if db, ok := pool.(*sql.DB); ok {
+			pools = append(pools, db)
+		}
+		return nil
+	})
+	if err = conn.Use(resolver); err != nil {
+		return pools, err
+	}
+	if conf.Sticky {
+		return pools, registerSticky(conn)
+	}
+	return pools, nil
+}
+
+func (conf *ConnectionConfig) hostDialectors(hosts []*HostConfig) ([]gorm.Dialector, error) {
+	var dialectors []gorm.Dialector
+	for _, host := range hosts {
+		dialector, err := conf.withHost(host).dialector()
+		if err != nil {
+			return nil, err
+		}
+		dialectors = append(dialectors, dialector)
+	}
+	return dialectors, nil
+}
+
+// Copy the connection config with the settings given by host.
+func (conf *ConnectionConfig) withHost(host *HostConfig) *ConnectionConfig {
+	merged := *conf
+	merged.Read, merged.Write = nil, nil
+	if host.DSN != "" {
+		merged.DSN = host.DSN
+	}
+	if host.Host != "" {
+		merged.Host = host.Host
+	}
+	if host.Port != 0 {
+		merged.Port = host.Port
+	}
+	if host.User != "" {
+		merged.User = host.User
+	}
+	if host.Password != "" {
+		merged.Password = host.Password
+	}
+	return &merged
+}
+
+// The first configured write host is the one gorm opens the connection on.
+func (conf *ConnectionConfig) primary() *ConnectionConfig {
+	if len(conf.Write) == 0 {
+		return conf
+	}
+	return conf.withHost(conf.Write[0])
+}
+
+// Send reads made through a sticky context which already wrote back to the
+// write hosts, reusing the source switch dbresolver installs for writes.
+func registerSticky(conn *gorm.DB) error {
+	callbacks := conn.Callback()
+	toSource := stick(callbacks.Create().Get("gorm:db_resolver"))
+	if err := callbacks.Query().Before("gorm:query").Register("service:sticky_read", toSource); err != nil {
+		return err
+	}
+	if err := callbacks.Row().Before("gorm:row").Register("service:sticky_read", toSource); err != nil {
+		return err
+	}
+	if err := callbacks.Raw().Before("gorm:raw").Register("service:sticky_read", toSource); err != nil {
+		return err
+	}
+	if err := callbacks.Create().After("gorm:create").Register("service:sticky_write", markWritten); err != nil {
+		return err
+	}
+	if err := callbacks.Update().After("gorm:update").Register("service:sticky_write", markWritten); err != nil {
+		return err
+	}
+	if err := callbacks.Delete().After("gorm:delete").Register("service:sticky_write", markWritten); err != nil {
+		return err
+	}
+	return callbacks.Raw().After("gorm:raw").Register("service:sticky_write", markWritten)
+}
+
+func stickyOf(db *gorm.DB) *sticky {
+	if db.Statement.Context == nil {
+		return nil
+	}
+	state, _ := db.Statement.Context.Value(stickyKey{}).(*sticky)
+	return state
+}
+
+func stick(toSource func(*gorm.DB)) func(*gorm.DB) {
+	return func(db *gorm.DB) {
+		if state := stickyOf(db); state != nil && atomic.LoadInt32(&state.written) == 1 {
+			toSource(db)
+		}
+	}
+}
+
+func markWritten(db *gorm.DB) {
+	state := stickyOf(db)
+	if state == nil || db.Error != nil {
+		return
+	}
+	if sql := strings.TrimSpace(db.Statement.SQL.String()); len(sql) >= 6 && strings.EqualFold(sql[:6], "select") {
+		return
+	}
+	atomic.StoreInt32(&state.written, 1)
+}
@@ -0,0 +1,198 @@
+package schema
+
+import (
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// Parts of mysql's SHOW CREATE output tied to the current server.
+var (
+	autoIncrementPattern = regexp.MustCompile(` AUTO_INCREMENT=\d+`)
+	definerPattern       = regexp.MustCompile(` DEFINER=\S+`)
+)
+
+// Get the statements creating the tables, indexes and views of the current
+// database but the excepted tables, rows are left out. They are read from the
+// server itself, no client tool is needed.
+func Dump(db *gorm.DB, except ...string) ([]string, error) {
+	all, err := Tables(db)
+	if err != nil {
+		return nil, err
+	}
+	var tables []string
+	for _, table := range all {
+		kept := true
+		for _, name := range except {
+			kept = kept && name != table
+		}
+		if kept {
+			tables = append(tables, table)
+		}
+	}
+	switch db.Dialector.Name() {
+	case "sqlite":
+		return dumpSqlite(db, tables)
+	case "mysql":
+		return dumpMysql(db, tables)
+	case "postgres":
+		return dumpPostgres(db, tables)
+	}
+	return nil, fmt.Errorf("dumping the schema is not supported on %s", db.Dialector.Name())
+}
+
+func dumpSqlite(db *gorm.DB, tables []string) ([]string, error) {
+	var statements []string
+	// Tables first, the indexes, views and triggers depend on them.
+	if err := db.Raw("SELECT sql FROM sqlite_master WHERE sql IS NOT NULL AND name NOT LIKE 'sqlite_%' "+
+		"AND (type = 'view' OR tbl_name IN ?) "+
+		"ORDER BY CASE type WHEN 'table' THEN 0 WHEN 'index' THEN 1 WHEN 'view' THEN 2 ELSE 3 END, name",
+		tables,
+	).Scan(&statements).Error; err != nil {
+		return nil, err
+	}
+	return statements, nil
+}
+
+func dumpMysql(db *gorm.DB, tables []string) ([]string, error) {
+	// Tables are created in name order, whatever their foreign keys reference.
+	statements := []string{"SET FOREIGN_KEY_CHECKS = 0"}
+	for _, table := range tables {
+		create, err := showCreate(db, "TABLE", table)
+		if err != nil {
+			return nil, err
+		}
+		statements = append(statements, autoIncrementPattern.ReplaceAllString(create, ""))
+	}
+	views, err := Views(db)
+	if err != nil {
+		return nil, err
+	}
+	for _, view := range views {
+		create, err := showCreate(db, "VIEW", view)
+		if err != nil {
+			return nil, err
+		}
+		statements = append(statements, definerPattern.ReplaceAllString(create, ""))
+	}
+	return append(statements, "SET FOREIGN_KEY_CHECKS = 1"), nil
+}
+
+// Get the statement of SHOW CREATE TABLE or VIEW, its second column.
+func showCreate(db *gorm.DB, kind, name string) (string, error) {
+	var quoted strings.Builder
+	db.Dialector.QuoteTo(&quoted, name)
+	rows, err := db.Raw(fmt.Sprintf("SHOW CREATE %s %s", kind, quoted.String())).Rows()
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		return "", err
+	}
+	if !rows.Next() {
+		return "", fmt.Errorf("%s %s does not exist", strings.ToLower(kind), name)
+	}
+	values := make([]interface{}, len(columns))
+	for i := range values {
+		values[i] = new(sql.RawBytes)
+	}
+	var create string
+	values[1] = &create
+	if err = rows.Scan(values...); err != nil {
+		return "", err
+	}
+	return create, rows.Err()
+}
+
+// Postgres has no SHOW CREATE, the statements are built from the catalog:
+// sequences, then tables with their columns and constraints but the foreign
+// keys, added once every table exists, then indexes and views.
+func dumpPostgres(db *gorm.DB, tables []string) ([]string, error) {
+	var statements, foreignKeys []string
+	var sequences []string
+	if err := db.Raw("SELECT sequencename FROM pg_sequences WHERE schemaname = CURRENT_SCHEMA() ORDER BY sequencename").
+		Scan(&sequences).Error; err != nil {
+		return nil, err
+	}
+	for _, sequence := range sequences {
+		statements = append(statements, fmt.Sprintf("CREATE SEQUENCE IF NOT EXISTS %s", pgQuote(sequence)))
+	}
+	for _, table := range tables {
+		var columns []struct {
+			Name    string
+			Type    string
+			NotNull bool
+			Default sql.NullString
+		}
+		if err := db.Raw("SELECT a.attname AS name, format_type(a.atttypid, a.atttypmod) AS type, "+
+			"a.attnotnull AS not_null, pg_get_expr(d.adbin, d.adrelid) AS \"default\" FROM pg_attribute a "+
+			"LEFT JOIN pg_attrdef d ON d.adrelid = a.attrelid AND d.adnum = a.attnum "+
+			"WHERE a.attrelid = CAST(? AS regclass) AND a.attnum > 0 AND NOT a.attisdropped ORDER BY a.attnum",
+			pgQuote(table),
+		).Scan(&columns).Error; err != nil {
+			return nil, err
+		}
+		var constraints []struct {
+			Name       string
+			Type       string
+			Definition string
+		}
+		if err := db.Raw("SELECT conname AS name, contype AS type, pg_get_constraintdef(oid) AS definition "+
+			"FROM pg_constraint WHERE conrelid = CAST(? AS regclass) ORDER BY conname", pgQuote(table),
+		).Scan(&constraints).Error; err != nil {
+			return nil, err
+		}
+		var lines []string
+		for _, column := range columns {
+			line := pgQuote(column.Name) + " " + column.Type
+			if column.Default.Valid {
+				line += " DEFAULT " + column.Default.String
+			}
+			if column.NotNull {
+				line += " NOT NULL"
+			}
+			lines = append(lines, line)
+		}
+		for _, constraint := range constraints {
+			definition := fmt.Sprintf("CONSTRAINT %s %s", pgQuote(constraint.Name), constraint.Definition)
+			if constraint.Type == "f" {
+				foreignKeys = append(foreignKeys, fmt.Sprintf("ALTER TABLE %s ADD %s", pgQuote(table), definition))
+			} else {
+				lines = append(lines, definition)
+			}
+		}
+		statements = append(statements, fmt.Sprintf("CREATE TABLE %s (\n  %s\n)", pgQuote(table), strings.Join(lines, ",\n  ")))
+	}
+	statements = append(statements, foreignKeys...)
+	// Indexes backing constraints are created along with them.
+	var indexes []string
+	if err := db.Raw("SELECT indexdef FROM pg_indexes i WHERE schemaname = CURRENT_SCHEMA() AND tablename IN ? "+
+		"AND NOT EXISTS (SELECT 1 FROM pg_constraint c WHERE c.conname = i.indexname) ORDER BY tablename, indexname",
+		tables,
+	).Scan(&indexes).Error; err != nil {
+		return nil, err
+	}
+	statements = append(statements, indexes...)
+	var views []struct {
+		Name       string
+		Definition string
+	}
+	if err := db.Raw("SELECT viewname AS name, definition FROM pg_views WHERE schemaname = CURRENT_SCHEMA() ORDER BY viewname").
+		Scan(&views).Error; err != nil {
+		return nil, err
+	}
+	for _, view := range views {
+		statements = append(statements, fmt.Sprintf("CREATE VIEW %s AS %s", pgQuote(view.Name),
+			strings.TrimSuffix(strings.TrimSpace(view.Definition), ";")))
+	}
+	return statements, nil
+}
+
+func pgQuote(name string) string {
+	return `"` + strings.Replace(name, `"`, `""`, -1) + `"`
+}
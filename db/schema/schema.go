@@ -0,0 +1,230 @@
+package schema
+
+import (
+	"fmt"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Index of a table.
+type Index struct {
+	Name    string
+	Columns []string
+	Unique  bool
+}
+
+// ForeignKey of a table.
+type ForeignKey struct {
+	Name           string
+	Columns        []string
+	ForeignTable   string
+	ForeignColumns []string
+}
+
+// Queries of every supported dialect, keyed by dialect name.
+type queries map[string]string
+
+func (q queries) get(db *gorm.DB, what string) (string, error) {
+	query, ok := q[db.Dialector.Name()]
+	if !ok {
+		return "", fmt.Errorf("listing %s is not supported on %s", what, db.Dialector.Name())
+	}
+	return query, nil
+}
+
+var tableQueries = queries{
+	"mysql":     "SELECT table_name FROM information_schema.tables WHERE table_schema = DATABASE() AND table_type = 'BASE TABLE' ORDER BY table_name",
+	"postgres":  "SELECT tablename FROM pg_tables WHERE schemaname = CURRENT_SCHEMA() ORDER BY tablename",
+	"sqlite":    "SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%' ORDER BY name",
+	"sqlserver": "SELECT TABLE_NAME FROM INFORMATION_SCHEMA.TABLES WHERE TABLE_TYPE = 'BASE TABLE' ORDER BY TABLE_NAME",
+}
+
+var viewQueries = queries{
+	"mysql":     "SELECT table_name FROM information_schema.views WHERE table_schema = DATABASE() ORDER BY table_name",
+	"postgres":  "SELECT viewname FROM pg_views WHERE schemaname = CURRENT_SCHEMA() ORDER BY viewname",
+	"sqlite":    "SELECT name FROM sqlite_master WHERE type = 'view' ORDER BY name",
+	"sqlserver": "SELECT TABLE_NAME FROM INFORMATION_SCHEMA.VIEWS ORDER BY TABLE_NAME",
+}
+
+var versionQueries = queries{
+	"mysql":     "SELECT VERSION()",
+	"postgres":  "SHOW server_version",
+	"sqlite":    "SELECT sqlite_version()",
+	"sqlserver": "SELECT SERVERPROPERTY('ProductVersion')",
+}
+
+var sizeQueries = queries{
+	"mysql":     "SELECT COALESCE(SUM(data_length + index_length), 0) FROM information_schema.tables WHERE table_schema = DATABASE()",
+	"postgres":  "SELECT pg_database_size(current_database())",
+	"sqlite":    "SELECT page_count * page_size FROM pragma_page_count(), pragma_page_size()",
+	"sqlserver": "SELECT SUM(CAST(size AS bigint)) * 8192 FROM sys.database_files",
+}
+
+// Rows of (index, column, unique) ordered by index and column position.
+var indexQueries = queries{
+	"mysql": "SELECT index_name, column_name, non_unique = 0 FROM information_schema.statistics " +
+		"WHERE table_schema = DATABASE() AND table_name = ? ORDER BY index_name, seq_in_index",
+	"postgres": "SELECT i.relname, a.attname, ix.indisunique FROM pg_class t " +
+		"JOIN pg_index ix ON t.oid = ix.indrelid JOIN pg_class i ON i.oid = ix.indexrelid " +
+		"JOIN pg_attribute a ON a.attrelid = t.oid AND a.attnum = ANY(ix.indkey) " +
+		"WHERE t.relname = ? AND t.relnamespace = CURRENT_SCHEMA()::regnamespace " +
+		"ORDER BY i.relname, array_position(ix.indkey::int2[], a.attnum)",
+	"sqlite": "SELECT il.name, ii.name, il.\"unique\" FROM pragma_index_list(?) il, pragma_index_info(il.name) ii " +
+		"ORDER BY il.name, ii.seqno",
+	"sqlserver": "SELECT i.name, c.name, i.is_unique FROM sys.indexes i " +
+		"JOIN sys.index_columns ic ON i.object_id = ic.object_id AND i.index_id = ic.index_id " +
+		"JOIN sys.columns c ON c.object_id = ic.object_id AND c.column_id = ic.column_id " +
+		"WHERE i.object_id = OBJECT_ID(?) ORDER BY i.name, ic.key_ordinal",
+}
+
+// Rows of (constraint, column, foreign table, foreign column) ordered by
+// constraint and column position.
+var foreignKeyQueries = queries{
+	"mysql": "SELECT constraint_name, column_name, referenced_table_name, referenced_column_name " +
+		"FROM information_schema.key_column_usage WHERE table_schema = DATABASE() AND table_name = ? " +
+		"AND referenced_table_name IS NOT NULL ORDER BY constraint_name, ordinal_position",
+	"postgres": "SELECT c.conname, a.attname, f.relname, fa.attname FROM pg_constraint c " +
+		"JOIN pg_class t ON t.oid = c.conrelid JOIN pg_class f ON f.oid = c.confrelid " +
+		"CROSS JOIN LATERAL unnest(c.conkey, c.confkey) WITH ORDINALITY AS k(attnum, fattnum, position) " +
+		"JOIN pg_attribute a ON a.attrelid = c.conrelid AND a.attnum = k.attnum " +
+		"JOIN pg_attribute fa ON fa.attrelid = c.confrelid AND fa.attnum = k.fattnum " +
+		"WHERE c.contype = 'f' AND t.relname = ? AND t.relnamespace = CURRENT_SCHEMA()::regnamespace " +
+		"ORDER BY c.conname, k.position",
+	"sqlite": "SELECT CAST(id AS text), \"from\", \"table\", \"to\" FROM pragma_foreign_key_list(?) ORDER BY id, seq",
+	"sqlserver": "SELECT fk.name, pc.name, OBJECT_NAME(fk.referenced_object_id), rc.name FROM sys.foreign_keys fk " +
+		"JOIN sys.foreign_key_columns fkc ON fk.object_id = fkc.constraint_object_id " +
+		"JOIN sys.columns pc ON pc.object_id = fkc.parent_object_id AND pc.column_id = fkc.parent_column_id " +
+		"JOIN sys.columns rc ON rc.object_id = fkc.referenced_object_id AND rc.column_id = fkc.referenced_column_id " +
+		"WHERE fk.parent_object_id = OBJECT_ID(?) ORDER BY fk.name, fkc.constraint_column_id",
+}
+
+// Get the base tables of the current database, views excluded.
+func Tables(db *gorm.DB) ([]string, error) {
+	return list(db, tableQueries, "tables")
+}
+
+// Get the views of the current database.
+func Views(db *gorm.DB) ([]string, error) {
+	return list(db, viewQueries, "views")
+}
+
+// Get the server version.
+func Version(db *gorm.DB) (string, error) {
+	query, err := versionQueries.get(db, "the version")
+	if err != nil {
+		return "", err
+	}
+	var version string
+	return version, db.Raw(query).Row().Scan(&version)
+}
+
+// Get the size of the current database in bytes.
+func Size(db *gorm.DB) (int64, error) {
+	query, err := sizeQueries.get(db, "the size")
+	if err != nil {
+		return 0, err
+	}
+	var size int64
+	return size, db.Raw(query).Row().Scan(&size)
+}
+
+// Get the indexes of a table.
+func Indexes(db *gorm.DB, table string) ([]*Index, error) {
+	query, err := indexQueries.get(db, "indexes")
+	if err != nil {
+		return nil, err
+	}
+	rows, err := db.Raw(query, table).Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var indexes []*Index
+	for rows.Next() {
+		var name, column string
+		var unique bool
+		if err = rows.Scan(&name, &column, &unique); err != nil {
+			return nil, err
+		}
+		if len(indexes) == 0 || indexes[len(indexes)-1].Name != name {
+			indexes = append(indexes, &Index{Name: name, Unique: unique})
+		}
+		index := indexes[len(indexes)-1]
+		index.Columns = append(index.Columns, column)
+	}
+	return indexes, rows.Err()
+}
+
+// Get the foreign keys of a table.
+func ForeignKeys(db *gorm.DB, table string) ([]*ForeignKey, error) {
+	query, err := foreignKeyQueries.get(db, "foreign keys")
+	if err != nil {
+		return nil, err
+	}
+	rows, err := db.Raw(query, table).Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var keys []*ForeignKey
+	for rows.Next() {
+		var name, column, foreignTable, foreignColumn string
+		if err = rows.Scan(&name, &column, &foreignTable, &foreignColumn); err != nil {
+			return nil, err
+		}
+		if len(keys) == 0 || keys[len(keys)-1].Name != name {
+			keys = append(keys, &ForeignKey{Name: name, ForeignTable: foreignTable})
+		}
+		key := keys[len(keys)-1]
+		key.Columns = append(key.Columns, column)
+		key.ForeignColumns = append(key.ForeignColumns, foreignColumn)
+	}
+	return keys, rows.Err()
+}
+
+// Drop every view, then every table of the current database but the excepted
+// ones.
+func Wipe(db *gorm.DB, dropViews bool, except ...string) (tables, views []string, err error) {
+	if dropViews {
+		if views, err = Views(db); err != nil {
+			return nil, nil, err
+		}
+		for _, view := range views {
+			if err = db.Exec("DROP VIEW ?", clause.Table{Name: view}).Error; err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+	var all []string
+	if all, err = Tables(db); err != nil {
+		return nil, views, err
+	}
+	for _, table := range all {
+		kept := false
+		for _, name := range except {
+			kept = kept || name == table
+		}
+		if !kept {
+			tables = append(tables, table)
+		}
+	}
+	for _, table := range tables {
+		if err = db.Migrator().DropTable(table); err != nil {
+			return nil, views, err
+		}
+	}
+	return tables, views, nil
+}
+
+func list(db *gorm.DB, q queries, what string) ([]string, error) {
+	query, err := q.get(db, what)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	if err = db.Raw(query).Scan(&names).Error; err != nil {
+		return nil, err
+	}
+	return names, nil
+}
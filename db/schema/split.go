@@ -0,0 +1,85 @@
+package schema
+
+import (
+	"strings"
+)
+
+// Split sql into the statements terminated by a semicolon, the unterminated
+// remainder is returned as rest. Semicolons inside quotes, comments and, on
+// postgres, dollar quoted bodies do not end a statement.
+func Split(dialect, sql string) (statements []string, rest string) {
+	start := 0
+	for i := 0; i < len(sql); i++ {
+		switch c := sql[i]; {
+		case c == '\'' || c == '"' || (c == '`' && dialect == "mysql"):
+			end := closeQuote(dialect, sql, i, c)
+			if end < 0 {
+				return statements, sql[start:]
+			}
+			i = end
+		case c == '-' && strings.HasPrefix(sql[i:], "--"), c == '#' && dialect == "mysql":
+			end := strings.IndexByte(sql[i:], '\n')
+			if end < 0 {
+				return statements, sql[start:]
+			}
+			i += end
+		case c == '/' && strings.HasPrefix(sql[i:], "/*"):
+			end := strings.Index(sql[i+2:], "*/")
+			if end < 0 {
+				return statements, sql[start:]
+			}
+			i += end + 3
+		case c == '$' && dialect == "postgres":
+			tag := dollarTag(sql[i:])
+			if tag == "" {
+				continue
+			}
+			end := strings.Index(sql[i+len(tag):], tag)
+			if end < 0 {
+				return statements, sql[start:]
+			}
+			i += len(tag) + end + len(tag) - 1
+		case c == ';':
+			if statement := strings.TrimSpace(sql[start:i]); statement != "" {
+				statements = append(statements, statement)
+			}
+			start = i + 1
+		}
+	}
+	return statements, sql[start:]
+}
+
+// Get the index of the quote closing the one at start, -1 when unterminated.
+// Doubled quotes and, on mysql, backslash escapes are skipped.
+func closeQuote(dialect, sql string, start int, quote byte) int {
+	for i := start + 1; i < len(sql); i++ {
+		switch sql[i] {
+		case '\\':
+			if dialect == "mysql" && quote != '`' {
+				i++
+			}
+		case quote:
+			if i+1 < len(sql) && sql[i+1] == quote {
+				i++
+				continue
+			}
+			return i
+		}
+	}
+	return -1
+}
+
+// Get the $tag$ opening a postgres dollar quoted string, empty when s does not
+// start with one.
+func dollarTag(s string) string {
+	for i := 1; i < len(s); i++ {
+		c := s[i]
+		if c == '$' {
+			return s[:i+1]
+		}
+		if !(c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || i > 1 && c >= '0' && c <= '9') {
+			return ""
+		}
+	}
+	return ""
+}
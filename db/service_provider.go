@@ -2,22 +2,52 @@ package db
 
 import (
 	"github.com/goava/di"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/urionz/goofy"
 	"github.com/urionz/service/config"
 	"github.com/urionz/service/db/migrate"
 	"github.com/urionz/service/db/model"
 	"github.com/urionz/service/db/seed"
+	"github.com/urionz/service/health"
+	"github.com/urionz/service/log"
 )
 
+// Optional dependencies of the manager, sql is written to the default logger
+// when the log service is not registered and metrics to the default prometheus
+// registry when no registerer is.
+type managerDeps struct {
+	di.Inject
+	Logger     *log.Logger           `optional:"true"`
+	Registerer prometheus.Registerer `optional:"true"`
+}
+
 func NewServiceProvider(app goofy.IApplication, conf config.IConfig) error {
-	app.Provide(func() *Manager {
-		return NewManager(conf)
-	}, di.As(new(Factory)))
+	if err := conf.RegisterSection("database", new(Config)); err != nil {
+		return err
+	}
+	app.Provide(func(deps managerDeps) (*Manager, func(), error) {
+		if deps.Registerer == nil {
+			deps.Registerer = prometheus.DefaultRegisterer
+		}
+		metrics, err := NewMetrics(deps.Registerer)
+		if err != nil {
+			return nil, nil, err
+		}
+		manager := NewManager(conf).SetLogger(deps.Logger).SetMetrics(metrics)
+		return manager, func() {
+			if err := manager.CloseAll(); err != nil {
+				log.Error(err)
+			}
+		}, nil
+	}, di.As(new(Factory), new(migrate.Factory), new(health.Source)))
 	app.AddCommanders(
 		new(migrate.MakeCommand), new(migrate.Command),
 		new(migrate.RollbackCommand), new(migrate.StatusCommand),
 		new(migrate.FreshCommand), new(migrate.ResetCommand),
-		new(migrate.RefreshCommand), new(model.Command), new(seed.Command),
+		new(migrate.RefreshCommand), new(migrate.RenameLegacyCommand), new(migrate.DumpCommand),
+		new(model.Command), new(seed.Command),
+		new(MonitorCommand), new(ShowCommand), new(TableCommand), new(WipeCommand),
+		new(CliCommand),
 	)
 	return nil
 }
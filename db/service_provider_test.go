@@ -1,12 +1,30 @@
 package db_test
 
 import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/stretchr/testify/require"
 	"github.com/urionz/goofy"
 	"github.com/urionz/service/config"
 	"github.com/urionz/service/db"
+	"github.com/urionz/service/db/migrate"
+	"github.com/urionz/service/db/schema"
+	"github.com/urionz/service/health"
+	"github.com/urionz/service/log"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
 )
 
 func TestNewServiceProvider(t *testing.T) {
@@ -16,3 +34,961 @@ func TestNewServiceProvider(t *testing.T) {
 		}).Run()
 	})
 }
+
+type usersMigration struct {
+	ID       int
+	Username string `gorm:"column:name"`
+}
+
+func (*usersMigration) TableName() string {
+	return "users"
+}
+
+func (*usersMigration) MigrateTimestamp() int {
+	return 1614556800
+}
+
+func (*usersMigration) Name() string {
+	return "1614556800_create_users_table"
+}
+
+func (table *usersMigration) Up(db *gorm.DB) error {
+	return db.Migrator().CreateTable(table)
+}
+
+func (table *usersMigration) Down(db *gorm.DB) error {
+	return db.Migrator().DropTable(table)
+}
+
+func newSqliteWorkspace(t *testing.T, settings ...string) string {
+	workspace, err := ioutil.TempDir("", "db")
+	require.NoError(t, err)
+	database := filepath.ToSlash(filepath.Join(workspace, "test.db"))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(workspace, "config.toml"), []byte(
+		"[database]\ndefault = \"sqlite\"\n[database.conns.sqlite]\ndriver = \"sqlite\"\nname = \""+database+"\"\n"+
+			strings.Join(settings, "\n"),
+	), 0644))
+	return workspace
+}
+
+// Register files in place of every registered one until the test ends.
+func registerMigrations(t *testing.T, files ...migrate.File) {
+	migrate.Clear()
+	t.Cleanup(migrate.Clear)
+	migrate.Register(files...)
+}
+
+func TestSqliteMigrate(t *testing.T) {
+	workspace := newSqliteWorkspace(t)
+	defer os.RemoveAll(workspace)
+	registerMigrations(t, new(usersMigration))
+	require.Panics(t, func() {
+		migrate.Register(new(usersMigration))
+	})
+
+	app := goofy.New(goofy.SetWorkspace(workspace))
+	app.AddServices(config.NewServiceProvider, db.NewServiceProvider, func(manager db.Factory) {
+		conn := manager.Connection()
+		require.NotNil(t, conn)
+		require.Equal(t, "sqlite", conn.Dialector.Name())
+
+		_, _, err := app.Call("migrate")
+		require.NoError(t, err)
+		require.True(t, conn.Migrator().HasTable("users"))
+		require.NoError(t, conn.Exec("INSERT INTO users (name) VALUES (?)", "goofy").Error)
+
+		_, _, err = app.Call("migrate:fresh")
+		require.NoError(t, err)
+		var count int64
+		require.NoError(t, conn.Table("users").Count(&count).Error)
+		require.Equal(t, int64(0), count)
+
+		var batch int
+		require.NoError(t, conn.Table("migrations").Select("COALESCE(MAX(batch), 0)").Row().Scan(&batch))
+		require.Equal(t, 1, batch)
+
+		_, _, err = app.Call("migrate:rollback")
+		require.NoError(t, err)
+		require.False(t, conn.Migrator().HasTable("users"))
+	}).Run()
+}
+
+func TestReadWriteSplitting(t *testing.T) {
+	workspace, err := ioutil.TempDir("", "db")
+	require.NoError(t, err)
+	defer os.RemoveAll(workspace)
+	primary := filepath.ToSlash(filepath.Join(workspace, "primary.db"))
+	replica := filepath.ToSlash(filepath.Join(workspace, "replica.db"))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(workspace, "config.toml"), []byte(
+		"[database]\ndefault = \"sqlite\"\n[database.conns.sqlite]\ndriver = \"sqlite\"\nname = \""+primary+"\"\nsticky = true\n"+
+			"[[database.conns.sqlite.read]]\ndsn = \""+replica+"\"\n",
+	), 0644))
+
+	replicaConn, err := gorm.Open(sqlite.Open(replica), &gorm.Config{})
+	require.NoError(t, err)
+	require.NoError(t, replicaConn.Exec("CREATE TABLE users (id integer primary key, name text)").Error)
+	require.NoError(t, replicaConn.Exec("INSERT INTO users (name) VALUES ('a'), ('b')").Error)
+
+	goofy.New(goofy.SetWorkspace(workspace)).AddServices(config.NewServiceProvider, db.NewServiceProvider, func(manager db.Factory) {
+		conn := manager.Connection()
+		require.NotNil(t, conn)
+		require.NoError(t, conn.Exec("CREATE TABLE users (id integer primary key, name text)").Error)
+
+		count := func(tx *gorm.DB) int64 {
+			var count int64
+			require.NoError(t, tx.Table("users").Count(&count).Error)
+			return count
+		}
+		require.Equal(t, int64(2), count(conn))
+		require.Equal(t, int64(0), count(conn.Clauses(db.Write)))
+
+		ctx := db.Sticky(context.Background())
+		require.Equal(t, int64(2), count(conn.WithContext(ctx)))
+		require.NoError(t, conn.WithContext(ctx).Exec("INSERT INTO users (name) VALUES ('c')").Error)
+		require.Equal(t, int64(1), count(conn.WithContext(ctx)))
+		require.Equal(t, int64(2), count(conn))
+	}).Run()
+}
+
+func TestPoolStats(t *testing.T) {
+	workspace := newSqliteWorkspace(t, "max_open_conns = 3", "max_idle_conns = 1", "conn_max_lifetime = \"30m\"")
+	defer os.RemoveAll(workspace)
+
+	app := goofy.New(goofy.SetWorkspace(workspace))
+	app.AddServices(config.NewServiceProvider, db.NewServiceProvider, func(manager *db.Manager) {
+		stats, err := manager.Stats()
+		require.NoError(t, err)
+		require.Equal(t, 3, stats.MaxOpenConnections)
+
+		_, output, err := app.Call("db:monitor", "--database=sqlite")
+		require.NoError(t, err)
+		require.Contains(t, output, "Max Open")
+		require.Regexp(t, `sqlite\s+\|\d+\s+\|\d+\s+\|\d+\s+\|3\s`, output)
+	}).Run()
+}
+
+func TestConnectionLifecycle(t *testing.T) {
+	workspace := newSqliteWorkspace(t)
+	defer os.RemoveAll(workspace)
+
+	app := goofy.New(goofy.SetWorkspace(workspace))
+	app.AddServices(config.NewServiceProvider, db.NewServiceProvider, func(manager *db.Manager) {
+		require.Equal(t, []string{"sqlite"}, manager.Names())
+
+		_, err := manager.ConnectionE("missing")
+		require.EqualError(t, err, "database connection missing: not configured")
+		require.EqualError(t, manager.Ping(context.Background(), "missing"),
+			"database ping failed: missing: not configured")
+		require.Nil(t, manager.Connection("missing"))
+
+		conn, err := manager.ConnectionE()
+		require.NoError(t, err)
+		pool, err := conn.DB()
+		require.NoError(t, err)
+
+		reconnected, err := manager.Reconnect()
+		require.NoError(t, err)
+		require.NotSame(t, conn, reconnected)
+		require.Error(t, pool.Ping())
+		require.Same(t, reconnected, manager.Connection("sqlite"))
+
+		pool, err = reconnected.DB()
+		require.NoError(t, err)
+		app.(*goofy.Application).Cleanup()
+		require.Error(t, pool.Ping())
+	}).Run()
+}
+
+func newSqliteConfigure(t *testing.T, workspace string, settings map[string]interface{}) *config.Configure {
+	conn := map[string]interface{}{
+		"driver": "sqlite",
+		"name":   filepath.ToSlash(filepath.Join(workspace, "test.db")),
+	}
+	for key, value := range settings {
+		conn[key] = value
+	}
+	conf, err := config.NewConfigure(config.WithData(map[string]interface{}{
+		"database": map[string]interface{}{
+			"default": "sqlite",
+			"conns":   map[string]interface{}{"sqlite": conn},
+		},
+	}))
+	require.NoError(t, err)
+	return conf
+}
+
+func TestSqlLogging(t *testing.T) {
+	workspace, err := ioutil.TempDir("", "db")
+	require.NoError(t, err)
+	defer os.RemoveAll(workspace)
+	core, logs := observer.New(zapcore.DebugLevel)
+	manager := db.NewManager(newSqliteConfigure(t, workspace, map[string]interface{}{
+		"log_level": "info",
+		"redact":    true,
+	})).SetLogger(&log.Logger{Logger: zap.New(core)})
+	defer manager.CloseAll()
+
+	conn, err := manager.ConnectionE()
+	require.NoError(t, err)
+	require.NoError(t, conn.Exec("CREATE TABLE users (id integer primary key, name text)").Error)
+	require.NoError(t, conn.Exec("INSERT INTO users (name) VALUES (?)", "secret").Error)
+	require.Error(t, conn.Exec("SELECT * FROM missing").Error)
+	var user struct{ ID int }
+	require.ErrorIs(t, conn.Table("users").Where("name = ?", "nobody").First(&user).Error, gorm.ErrRecordNotFound)
+
+	entries := logs.FilterField(zap.String("connection", "sqlite")).All()
+	require.Len(t, entries, 4)
+	insert := entries[1].ContextMap()
+	require.Equal(t, zapcore.InfoLevel, entries[1].Level)
+	require.Equal(t, `INSERT INTO users (name) VALUES ('?')`, insert["sql"])
+	require.Equal(t, int64(1), insert["rows"])
+	require.Contains(t, insert["caller"], "service_provider_test.go")
+	require.Equal(t, zapcore.ErrorLevel, entries[2].Level)
+	require.Equal(t, zapcore.InfoLevel, entries[3].Level)
+
+	conn.Logger = conn.Logger.LogMode(logger.Silent)
+	require.NoError(t, conn.Exec("DELETE FROM users").Error)
+	require.Equal(t, 4, logs.FilterField(zap.String("connection", "sqlite")).Len())
+}
+
+func TestTransaction(t *testing.T) {
+	workspace, err := ioutil.TempDir("", "db")
+	require.NoError(t, err)
+	defer os.RemoveAll(workspace)
+	manager := db.NewManager(newSqliteConfigure(t, workspace, map[string]interface{}{"log_level": "silent"}))
+	defer manager.CloseAll()
+	conn, err := manager.ConnectionE()
+	require.NoError(t, err)
+	require.NoError(t, conn.Exec("CREATE TABLE users (id integer primary key, name text)").Error)
+	names := func() []string {
+		var names []string
+		require.NoError(t, conn.Table("users").Order("name").Pluck("name", &names).Error)
+		return names
+	}
+
+	var committed []string
+	failure := errors.New("failure")
+	err = manager.Transaction(context.Background(), "", func(tx *gorm.DB) error {
+		require.NoError(t, tx.Exec("INSERT INTO users (name) VALUES ('a')").Error)
+		db.AfterCommit(tx.Statement.Context, func() {
+			committed = append(committed, "a")
+		})
+		require.Equal(t, failure, manager.Transaction(tx.Statement.Context, "sqlite", func(tx *gorm.DB) error {
+			require.NoError(t, tx.Exec("INSERT INTO users (name) VALUES ('b')").Error)
+			db.AfterCommit(tx.Statement.Context, func() {
+				committed = append(committed, "b")
+			})
+			return failure
+		}))
+		require.Empty(t, committed)
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{"a"}, committed)
+	require.Equal(t, []string{"a"}, names())
+
+	require.Equal(t, failure, manager.Transaction(context.Background(), "", func(tx *gorm.DB) error {
+		require.NoError(t, tx.Exec("INSERT INTO users (name) VALUES ('c')").Error)
+		db.AfterCommit(tx.Statement.Context, func() {
+			t.Fatal("rolled back transaction must not run after commit callbacks")
+		})
+		return failure
+	}))
+	require.Equal(t, []string{"a"}, names())
+
+	attempts := 0
+	require.NoError(t, manager.Transaction(context.Background(), "", func(tx *gorm.DB) error {
+		attempts++
+		require.NoError(t, tx.Exec("INSERT INTO users (name) VALUES ('d')").Error)
+		if attempts == 1 {
+			return errors.New("database is locked")
+		}
+		return nil
+	}, &db.TxOptions{Retries: 2, Backoff: time.Millisecond}))
+	require.Equal(t, 2, attempts)
+	require.Equal(t, []string{"a", "d"}, names())
+
+	called := false
+	db.AfterCommit(context.Background(), func() {
+		called = true
+	})
+	require.True(t, called)
+}
+
+func TestInspectionCommands(t *testing.T) {
+	workspace := newSqliteWorkspace(t)
+	defer os.RemoveAll(workspace)
+
+	app := goofy.New(goofy.SetWorkspace(workspace))
+	app.AddServices(config.NewServiceProvider, db.NewServiceProvider, func(manager db.Factory) {
+		conn := manager.Connection()
+		require.NoError(t, conn.Exec("CREATE TABLE authors (id integer primary key, name text not null)").Error)
+		require.NoError(t, conn.Exec("CREATE TABLE posts (id integer primary key, author_id integer REFERENCES authors(id), title text)").Error)
+		require.NoError(t, conn.Exec("CREATE UNIQUE INDEX idx_posts_title ON posts (author_id, title)").Error)
+		require.NoError(t, conn.Exec("CREATE VIEW titles AS SELECT title FROM posts").Error)
+		require.NoError(t, conn.Exec("INSERT INTO authors (name) VALUES ('goofy')").Error)
+
+		_, output, err := app.Call("db:show")
+		require.NoError(t, err)
+		require.Regexp(t, `Driver\s+\|sqlite`, output)
+		require.Regexp(t, `Tables\s+\|2`, output)
+		require.Regexp(t, `Views\s+\|1`, output)
+
+		_, output, err = app.Call("db:table", "posts")
+		require.NoError(t, err)
+		require.Regexp(t, `(?i)author_id\s+\|integer`, output)
+		require.Regexp(t, `idx_posts_title\s+\|author_id, title\s+\|true`, output)
+		require.Contains(t, output, "authors(id)")
+		require.Contains(t, output, "Rows: 0")
+
+		_, _, err = app.Call("db:table", "missing")
+		require.Error(t, err)
+
+		_, _, err = app.Call("db:wipe", "--drop-views")
+		require.NoError(t, err)
+		tables, err := schema.Tables(conn)
+		require.NoError(t, err)
+		require.Empty(t, tables)
+		views, err := schema.Views(conn)
+		require.NoError(t, err)
+		require.Empty(t, views)
+	}).Run()
+}
+
+func TestCliCommand(t *testing.T) {
+	workspace := newSqliteWorkspace(t)
+	defer os.RemoveAll(workspace)
+	history := filepath.Join(workspace, "history")
+
+	app := goofy.New(goofy.SetWorkspace(workspace))
+	app.AddServices(config.NewServiceProvider, db.NewServiceProvider, func(manager db.Factory) {
+		_, output, err := app.Call("db:cli", "-e",
+			"CREATE TABLE notes (id integer primary key, body text); INSERT INTO notes (body) VALUES ('a;b'), (NULL)")
+		require.NoError(t, err)
+		require.Contains(t, output, "OK, 2 rows affected")
+
+		app.(*goofy.Application).Command.SetIn(strings.NewReader(
+			"SELECT id, body\n  FROM notes\n  ORDER BY id;\n\\d\n\\d notes\n\\q\nSELECT 1;\n",
+		))
+		// Flags keep their value between calls, reset --execute to go interactive.
+		_, output, err = app.Call("db", "--execute=", "--history", history)
+		require.NoError(t, err)
+		require.Contains(t, output, "     -> ")
+		require.Regexp(t, `1\s+\|a;b`, output)
+		require.Regexp(t, `2\s+\|NULL`, output)
+		require.Contains(t, output, "(2 rows)")
+		require.Regexp(t, `notes\s+\|table`, output)
+		require.Contains(t, output, "Rows: 2")
+		require.NotContains(t, output, "(1 rows)")
+
+		content, err := ioutil.ReadFile(history)
+		require.NoError(t, err)
+		require.Equal(t, "SELECT id, body FROM notes ORDER BY id;\n", string(content))
+
+		_, _, err = app.Call("db:cli", "-e", "SELECT * FROM missing")
+		require.Error(t, err)
+	}).Run()
+}
+
+func TestQueryMetrics(t *testing.T) {
+	workspace, err := ioutil.TempDir("", "db")
+	require.NoError(t, err)
+	defer os.RemoveAll(workspace)
+	registry := prometheus.NewRegistry()
+	metrics, err := db.NewMetrics(registry)
+	require.NoError(t, err)
+	core, logs := observer.New(zapcore.DebugLevel)
+	manager := db.NewManager(newSqliteConfigure(t, workspace, map[string]interface{}{
+		"log_level":      "silent",
+		"slow_threshold": 1,
+	})).SetLogger(&log.Logger{Logger: zap.New(core)}).SetMetrics(metrics)
+	defer manager.CloseAll()
+
+	conn, err := manager.ConnectionE()
+	require.NoError(t, err)
+	require.NoError(t, conn.Exec("CREATE TABLE users (id integer primary key, name text)").Error)
+	require.NoError(t, conn.Table("users").Create(map[string]interface{}{"name": "goofy"}).Error)
+	var names []string
+	require.NoError(t, conn.Table("users").Pluck("name", &names).Error)
+	require.Error(t, conn.Table("missing").Pluck("name", &names).Error)
+	var count int64
+	require.NoError(t, conn.Table("users").Where(
+		"id IN (WITH RECURSIVE c(x) AS (SELECT 1 UNION ALL SELECT x + 1 FROM c WHERE x < 300000) SELECT x FROM c)",
+	).Count(&count).Error)
+	require.Equal(t, int64(1), count)
+
+	// Metrics registered again, by another manager, are shared.
+	_, err = db.NewMetrics(registry)
+	require.NoError(t, err)
+
+	families, err := registry.Gather()
+	require.NoError(t, err)
+	values := map[string]float64{}
+	for _, family := range families {
+		for _, metric := range family.GetMetric() {
+			labels := map[string]string{}
+			for _, label := range metric.GetLabel() {
+				labels[label.GetName()] = label.GetValue()
+			}
+			key := family.GetName() + "/" + labels["operation"] + "/" + labels["table"]
+			if histogram := metric.GetHistogram(); histogram != nil {
+				values[key] = float64(histogram.GetSampleCount())
+			} else {
+				values[key] = metric.GetCounter().GetValue()
+			}
+		}
+	}
+	require.Equal(t, float64(1), values["db_queries_total/create/users"])
+	require.Equal(t, float64(1), values["db_rows_affected_total/create/users"])
+	require.Equal(t, float64(2), values["db_queries_total/query/users"])
+	require.Equal(t, float64(2), values["db_query_duration_seconds/query/users"])
+	require.Equal(t, float64(1), values["db_query_errors_total/query/missing"])
+	require.Equal(t, float64(1), values["db_queries_total/raw/"])
+
+	slow := logs.FilterMessage("slow query").All()
+	require.NotEmpty(t, slow)
+	entry := slow[len(slow)-1].ContextMap()
+	require.Equal(t, "sqlite", entry["connection"])
+	require.Contains(t, entry["sql"], "WITH RECURSIVE")
+	require.NotContains(t, entry, "explain")
+}
+
+func TestPing(t *testing.T) {
+	workspace := newSqliteWorkspace(t,
+		"max_replication_lag = \"1s\"",
+		"[[database.conns.sqlite.read]]",
+		"dsn = \":memory:\"",
+		"[database.conns.broken]",
+		"driver = \"sqlite\"",
+		"name = \""+filepath.ToSlash(filepath.Join(os.TempDir(), "missing", "dir", "test.db"))+"\"",
+	)
+	defer os.RemoveAll(workspace)
+
+	app := goofy.New(goofy.SetWorkspace(workspace))
+	app.AddServices(config.NewServiceProvider, db.NewServiceProvider, health.NewServiceProvider, func(manager *db.Manager) {
+		require.NoError(t, manager.Ping(context.Background(), "sqlite"))
+		err := manager.Ping(context.Background())
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "broken:")
+		require.NotContains(t, err.Error(), "sqlite:")
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		require.Error(t, manager.Ping(ctx, "sqlite"))
+
+		_, output, err := app.Call("health:check")
+		require.Error(t, err)
+		require.Regexp(t, `db.broken\s+\|down`, output)
+		require.Regexp(t, `db.sqlite\s+\|up`, output)
+	}).Run()
+}
+
+type createPostsTable struct {
+	ID    int
+	Title string
+}
+
+func (*createPostsTable) TableName() string {
+	return "posts"
+}
+
+func (*createPostsTable) MigrateTimestamp() int {
+	return 1614556801
+}
+
+func (*createPostsTable) Name() string {
+	return "create_posts_table"
+}
+
+func (table *createPostsTable) Up(db *gorm.DB) error {
+	if !db.Migrator().HasTable(table) {
+		return db.Migrator().CreateTable(table)
+	}
+	return nil
+}
+
+func (table *createPostsTable) Down(db *gorm.DB) error {
+	return db.Migrator().DropTable(table)
+}
+
+// Creates its table, then fails while fail is set.
+type createCommentsTable struct {
+	ID   int
+	Body string
+	fail bool `gorm:"-"`
+}
+
+func (*createCommentsTable) TableName() string {
+	return "comments"
+}
+
+func (*createCommentsTable) MigrateTimestamp() int {
+	return 1614556802
+}
+
+func (*createCommentsTable) Name() string {
+	return "create_comments_table"
+}
+
+func (table *createCommentsTable) Up(db *gorm.DB) error {
+	if err := db.Migrator().CreateTable(table); err != nil {
+		return err
+	}
+	if table.fail {
+		return errors.New("comments failed")
+	}
+	return nil
+}
+
+func (table *createCommentsTable) Down(db *gorm.DB) error {
+	return db.Migrator().DropTable(table)
+}
+
+func TestMigrateTransactions(t *testing.T) {
+	workspace := newSqliteWorkspace(t)
+	defer os.RemoveAll(workspace)
+	comments := &createCommentsTable{fail: true}
+	registerMigrations(t, new(createPostsTable), comments)
+
+	app := goofy.New(goofy.SetWorkspace(workspace))
+	app.AddServices(config.NewServiceProvider, db.NewServiceProvider, func(manager db.Factory) {
+		conn := manager.Connection()
+		ran := func(name string) bool {
+			var count int64
+			require.NoError(t, conn.Table("migrations").Where("migration = ?", name).Count(&count).Error)
+			return count > 0
+		}
+
+		_, output, err := app.Call("migrate", "--pretend")
+		require.Error(t, err)
+		require.Contains(t, output, "create_posts_table:\n  CREATE TABLE `posts`")
+		require.False(t, conn.Migrator().HasTable("posts"))
+		require.False(t, conn.Migrator().HasTable("migrations"))
+
+		// Each migration runs in its own transaction.
+		_, _, err = app.Call("migrate", "--pretend=false")
+		require.Error(t, err)
+		require.True(t, conn.Migrator().HasTable("posts"))
+		require.True(t, ran("create_posts_table"))
+		require.False(t, conn.Migrator().HasTable("comments"))
+		require.False(t, ran("create_comments_table"))
+
+		_, _, err = app.Call("migrate:rollback")
+		require.NoError(t, err)
+		require.False(t, conn.Migrator().HasTable("posts"))
+
+		// The whole batch is rolled back.
+		_, _, err = app.Call("migrate", "--transaction=batch")
+		require.Error(t, err)
+		require.False(t, conn.Migrator().HasTable("posts"))
+		require.False(t, ran("create_posts_table"))
+
+		comments.fail = false
+		_, _, err = app.Call("migrate")
+		require.NoError(t, err)
+		require.True(t, conn.Migrator().HasTable("posts"))
+		require.True(t, conn.Migrator().HasTable("comments"))
+
+		// Nothing left to run is not an error.
+		_, _, err = app.Call("migrate")
+		require.NoError(t, err)
+
+		_, _, err = app.Call("migrate", "--transaction=nested")
+		require.Error(t, err)
+	}).Run()
+}
+
+func TestMigrationLock(t *testing.T) {
+	workspace := newSqliteWorkspace(t)
+	defer os.RemoveAll(workspace)
+	registerMigrations(t, new(usersMigration))
+
+	app := goofy.New(goofy.SetWorkspace(workspace))
+	app.AddServices(config.NewServiceProvider, db.NewServiceProvider, func(manager db.Factory) {
+		conn := manager.Connection()
+		locks := func() int64 {
+			var count int64
+			require.NoError(t, conn.Table("migrations_lock").Count(&count).Error)
+			return count
+		}
+
+		// The lock is released once migrating is done.
+		_, _, err := app.Call("migrate")
+		require.NoError(t, err)
+		require.True(t, conn.Migrator().HasTable("users"))
+		require.EqualValues(t, 0, locks())
+
+		_, _, err = app.Call("migrate:rollback")
+		require.NoError(t, err)
+		require.False(t, conn.Migrator().HasTable("users"))
+
+		// Another process is migrating.
+		require.NoError(t, conn.Exec(
+			"INSERT INTO migrations_lock (name, owner, acquired_at) VALUES (?, ?, ?)",
+			"migrations", "elsewhere:1", time.Now(),
+		).Error)
+		_, _, err = app.Call("migrate", "--lock-timeout=100ms")
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "elsewhere:1")
+		require.False(t, conn.Migrator().HasTable("users"))
+
+		_, _, err = app.Call("migrate:refresh", "--lock-timeout=100ms")
+		require.Error(t, err)
+
+		_, _, err = app.Call("migrate", "--no-lock")
+		require.NoError(t, err)
+		require.True(t, conn.Migrator().HasTable("users"))
+		require.EqualValues(t, 1, locks())
+	}).Run()
+}
+
+func TestMigrateRollbackSelection(t *testing.T) {
+	workspace := newSqliteWorkspace(t)
+	defer os.RemoveAll(workspace)
+	registerMigrations(t, new(usersMigration), new(createPostsTable), new(createCommentsTable))
+
+	app := goofy.New(goofy.SetWorkspace(workspace))
+	app.AddServices(config.NewServiceProvider, db.NewServiceProvider, func(manager db.Factory) {
+		conn := manager.Connection()
+		batches := func() map[string]int {
+			var rows []*migrate.Model
+			require.NoError(t, conn.Table("migrations").Find(&rows).Error)
+			batches := make(map[string]int)
+			for _, row := range rows {
+				batches[row.Migration] = row.Batch
+			}
+			return batches
+		}
+
+		// Only the next two migrations run, in a single batch.
+		_, _, err := app.Call("migrate", "--step=2")
+		require.NoError(t, err)
+		require.Equal(t, map[string]int{"1614556800_create_users_table": 1, "create_posts_table": 1}, batches())
+
+		_, _, err = app.Call("migrate", "--step=0")
+		require.NoError(t, err)
+		require.Equal(t, 2, batches()["create_comments_table"])
+
+		// The last two migrations ran are rolled back across batches, their
+		// names sort the other way round.
+		_, _, err = app.Call("migrate:rollback", "--step=2")
+		require.NoError(t, err)
+		require.Equal(t, map[string]int{"1614556800_create_users_table": 1}, batches())
+		require.False(t, conn.Migrator().HasTable("posts"))
+
+		_, _, err = app.Call("migrate")
+		require.NoError(t, err)
+		_, _, err = app.Call("migrate:rollback", "--step=0", "--batch=1")
+		require.NoError(t, err)
+		require.Equal(t, map[string]int{"create_posts_table": 2, "create_comments_table": 2}, batches())
+		require.False(t, conn.Migrator().HasTable("users"))
+
+		_, _, err = app.Call("migrate")
+		require.NoError(t, err)
+		_, _, err = app.Call("migrate:rollback", "--batch=0", "--target=create_posts_table")
+		require.NoError(t, err)
+		require.Equal(t, map[string]int{"create_posts_table": 2}, batches())
+		require.False(t, conn.Migrator().HasTable("comments"))
+		require.False(t, conn.Migrator().HasTable("users"))
+
+		_, _, err = app.Call("migrate:rollback", "--target=create_users_table")
+		require.Error(t, err)
+		_, _, err = app.Call("migrate:rollback", "--step=1", "--target=create_posts_table")
+		require.Error(t, err)
+
+		// Nothing left in the last batch but the target itself.
+		_, _, err = app.Call("migrate:rollback", "--step=0", "--target=")
+		require.NoError(t, err)
+		require.Empty(t, batches())
+	}).Run()
+}
+
+func TestRenameLegacyMigrations(t *testing.T) {
+	workspace := newSqliteWorkspace(t)
+	defer os.RemoveAll(workspace)
+	registerMigrations(t, new(usersMigration), new(createPostsTable), new(createCommentsTable))
+
+	app := goofy.New(goofy.SetWorkspace(workspace))
+	app.AddServices(config.NewServiceProvider, db.NewServiceProvider, func(manager db.Factory) {
+		conn := manager.Connection()
+		names := func() []string {
+			var names []string
+			require.NoError(t, conn.Table("migrations").Order("id").Pluck("migration", &names).Error)
+			return names
+		}
+
+		// Ran before files could be named, under the name of their type.
+		repository := migrate.NewDBMigration(conn)
+		require.NoError(t, repository.Log("users_migration", 1))
+		require.NoError(t, conn.Migrator().CreateTable(new(usersMigration)))
+
+		// Nothing runs until the legacy rows are renamed.
+		_, _, err := app.Call("migrate")
+		require.Error(t, err)
+		require.Equal(t, []string{"users_migration"}, names())
+		require.False(t, conn.Migrator().HasTable("posts"))
+
+		_, _, err = app.Call("migrate:rename-legacy")
+		require.NoError(t, err)
+		require.Equal(t, []string{"1614556800_create_users_table"}, names())
+
+		_, _, err = app.Call("make:migration", "create_tags_table")
+		require.NoError(t, err)
+		stub, err := ioutil.ReadFile(filepath.Join(workspace, "databases", "migration", "create_tags_table.go"))
+		require.NoError(t, err)
+		require.Contains(t, string(stub), "type CreateTagsTable struct")
+		require.Contains(t, string(stub), `"github.com/urionz/service/db/migrate"`)
+		require.Contains(t, string(stub), `"github.com/urionz/service/db/model"`)
+		require.Regexp(t, `return "\d+_create_tags_table"`, string(stub))
+
+		_, _, err = app.Call("migrate")
+		require.NoError(t, err)
+		require.Equal(t, []string{
+			"1614556800_create_users_table", "create_posts_table", "create_comments_table",
+		}, names())
+	}).Run()
+}
+
+func TestSQLMigrations(t *testing.T) {
+	workspace := newSqliteWorkspace(t)
+	defer os.RemoveAll(workspace)
+	dir := filepath.Join(workspace, "databases", "migration")
+	require.NoError(t, os.MkdirAll(dir, os.ModePerm))
+	for name, content := range map[string]string{
+		"1614556799_create_tags_table.up.sql": "CREATE TABLE tags (id integer primary key, name text);\n" +
+			"-- A semicolon; in a comment\nINSERT INTO tags (name) VALUES ('a;b');\n" +
+			"INSERT INTO tags (name) VALUES ('c')\n-- the end\n",
+		"1614556799_create_tags_table.down.sql": "DROP TABLE tags;\n/* Nothing\n   else to drop. */\n",
+		"README.md":                             "ignored",
+	} {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644))
+	}
+	registerMigrations(t, new(usersMigration))
+	require.NoError(t, migrate.RegisterSQLDir(dir))
+
+	app := goofy.New(goofy.SetWorkspace(workspace))
+	app.AddServices(config.NewServiceProvider, db.NewServiceProvider, func(manager db.Factory) {
+		conn := manager.Connection()
+
+		// Ordered with the go migrations by timestamp.
+		_, _, err := app.Call("migrate")
+		require.NoError(t, err)
+		var names []string
+		require.NoError(t, conn.Table("migrations").Order("id").Limit(2).Pluck("migration", &names).Error)
+		require.Equal(t, []string{"1614556799_create_tags_table", "1614556800_create_users_table"}, names)
+		var tags []string
+		require.NoError(t, conn.Table("tags").Order("id").Pluck("name", &tags).Error)
+		require.Equal(t, []string{"a;b", "c"}, tags)
+
+		_, _, err = app.Call("migrate:reset")
+		require.NoError(t, err)
+		require.False(t, conn.Migrator().HasTable("tags"))
+	}).Run()
+}
+
+type createEventsTable struct {
+	ID   int
+	Kind string
+}
+
+func (*createEventsTable) TableName() string {
+	return "events"
+}
+
+func (*createEventsTable) MigrateTimestamp() int {
+	return 1614556804
+}
+
+func (*createEventsTable) Name() string {
+	return "1614556804_create_events_table"
+}
+
+func (*createEventsTable) Connection() string {
+	return "analytics"
+}
+
+func (table *createEventsTable) Up(db *gorm.DB) error {
+	return db.Migrator().CreateTable(table)
+}
+
+func (table *createEventsTable) Down(db *gorm.DB) error {
+	return db.Migrator().DropTable(table)
+}
+
+type createInvoicesTable struct {
+	ID     int
+	Amount int
+}
+
+func (*createInvoicesTable) TableName() string {
+	return "invoices"
+}
+
+func (*createInvoicesTable) MigrateTimestamp() int {
+	return 1614556805
+}
+
+func (*createInvoicesTable) Name() string {
+	return "1614556805_create_invoices_table"
+}
+
+func (table *createInvoicesTable) Up(db *gorm.DB) error {
+	return db.Migrator().CreateTable(table)
+}
+
+func (table *createInvoicesTable) Down(db *gorm.DB) error {
+	return db.Migrator().DropTable(table)
+}
+
+func TestMigrationGroups(t *testing.T) {
+	workspace := newSqliteWorkspace(t)
+	defer os.RemoveAll(workspace)
+	analytics := filepath.ToSlash(filepath.Join(workspace, "analytics.db"))
+	conf, err := os.OpenFile(filepath.Join(workspace, "config.toml"), os.O_APPEND|os.O_WRONLY, 0644)
+	require.NoError(t, err)
+	_, err = conf.WriteString("[database.conns.analytics]\ndriver = \"sqlite\"\nname = \"" + analytics + "\"\n")
+	require.NoError(t, err)
+	require.NoError(t, conf.Close())
+	reports := filepath.Join(workspace, "reports")
+	require.NoError(t, os.MkdirAll(reports, os.ModePerm))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(reports, "1614556806_create_reports_table.up.sql"),
+		[]byte("CREATE TABLE reports (id integer primary key);"), 0644))
+	registerMigrations(t, new(usersMigration), new(createEventsTable))
+	migrate.RegisterGroup("billing", new(createInvoicesTable))
+
+	app := goofy.New(goofy.SetWorkspace(workspace))
+	app.AddServices(config.NewServiceProvider, db.NewServiceProvider, func(manager db.Factory) {
+		conn, other := manager.Connection(), manager.Connection("analytics")
+		ran := func(conn *gorm.DB, table, name string) bool {
+			var count int64
+			require.NoError(t, conn.Table(table).Where("migration = ?", name).Count(&count).Error)
+			return count > 0
+		}
+
+		// Pinned migrations only run on their connection.
+		_, _, err := app.Call("migrate")
+		require.NoError(t, err)
+		require.True(t, conn.Migrator().HasTable("users"))
+		require.False(t, conn.Migrator().HasTable("events"))
+		require.False(t, conn.Migrator().HasTable("invoices"))
+
+		_, _, err = app.Call("migrate", "--database=analytics")
+		require.NoError(t, err)
+		require.True(t, other.Migrator().HasTable("events"))
+		require.True(t, ran(other, "migrations", "1614556804_create_events_table"))
+
+		// Groups are recorded in a table of their own.
+		_, _, err = app.Call("migrate", "--database=", "--group=billing")
+		require.NoError(t, err)
+		require.True(t, conn.Migrator().HasTable("invoices"))
+		require.True(t, ran(conn, "migrations_billing", "1614556805_create_invoices_table"))
+		require.False(t, ran(conn, "migrations", "1614556805_create_invoices_table"))
+
+		_, output, err := app.Call("migrate:status", "--group=billing")
+		require.NoError(t, err)
+		require.Regexp(t, `Yes\S*\s+\|1614556805_create_invoices_table\s+\|1`, output)
+		require.NotContains(t, output, "create_users_table")
+
+		_, _, err = app.Call("migrate:rollback", "--group=billing")
+		require.NoError(t, err)
+		require.False(t, conn.Migrator().HasTable("invoices"))
+		require.True(t, conn.Migrator().HasTable("users"))
+
+		_, _, err = app.Call("migrate", "--group=reports", "--path="+reports)
+		require.NoError(t, err)
+		require.True(t, conn.Migrator().HasTable("reports"))
+		require.True(t, ran(conn, "migrations_reports", "1614556806_create_reports_table"))
+		_, _, err = app.Call("migrate", "--group=default", "--path="+reports)
+		require.Error(t, err)
+		_, _, err = app.Call("migrate:fresh", "--group=billing")
+		require.Error(t, err)
+		require.True(t, conn.Migrator().HasTable("users"))
+
+		_, _, err = app.Call("migrate", "--group=default", "--path=", "--database=missing")
+		require.Error(t, err)
+		_, _, err = app.Call("migrate", "--database=", "--group=bad-name")
+		require.Error(t, err)
+
+		_, output, err = app.Call("migrate:status", "--group=default")
+		require.NoError(t, err)
+		require.Regexp(t, `Yes\S*\s+\|1614556800_create_users_table\s+\|1`, output)
+		require.NotContains(t, output, "create_events_table")
+	}).Run()
+}
+
+func TestSchemaDump(t *testing.T) {
+	workspace := newSqliteWorkspace(t)
+	defer os.RemoveAll(workspace)
+	dir := filepath.Join(workspace, "databases", "migration")
+	require.NoError(t, os.MkdirAll(dir, os.ModePerm))
+	for _, name := range []string{
+		"1614556799_create_tags_table.up.sql", "1614556799_create_tags_table.down.sql",
+		"create_users_table.go", "create_comments_table.go", "helpers.go",
+	} {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), []byte("-- "+name), 0644))
+	}
+	registerMigrations(t, new(usersMigration), new(createPostsTable), new(createCommentsTable))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(workspace, "1614556799_create_tags_table.up.sql"),
+		[]byte("CREATE TABLE tags (id integer primary key);"), 0644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(workspace, "1614556799_create_tags_table.down.sql"),
+		[]byte("DROP TABLE tags;"), 0644))
+	require.NoError(t, migrate.RegisterSQLDir(workspace))
+
+	app := goofy.New(goofy.SetWorkspace(workspace))
+	app.AddServices(config.NewServiceProvider, db.NewServiceProvider, func(manager db.Factory) {
+		conn := manager.Connection()
+		batches := func() map[string]int {
+			var rows []*migrate.Model
+			require.NoError(t, conn.Table("migrations").Find(&rows).Error)
+			batches := make(map[string]int)
+			for _, row := range rows {
+				batches[row.Migration] = row.Batch
+			}
+			return batches
+		}
+
+		_, _, err := app.Call("migrate")
+		require.NoError(t, err)
+		_, _, err = app.Call("migrate:rollback", "--target=create_posts_table")
+		require.NoError(t, err)
+		require.NoError(t, conn.Exec("INSERT INTO users (name) VALUES ('goofy')").Error)
+		// Recorded but not registered, its file is kept.
+		require.NoError(t, conn.Exec("INSERT INTO migrations (migration, batch) VALUES ('1614556790_helpers', 1)").Error)
+		ran := batches()
+
+		_, _, err = app.Call("schema:dump", "--prune")
+		require.NoError(t, err)
+		dump, err := ioutil.ReadFile(filepath.Join(workspace, "databases", "schema", "sqlite.sql"))
+		require.NoError(t, err)
+		require.Contains(t, string(dump), "CREATE TABLE `users`")
+		require.Contains(t, string(dump), "INSERT INTO `migrations` (id, migration, batch) VALUES (3, 'create_posts_table', 1);")
+		require.NotContains(t, string(dump), "goofy")
+		require.NotContains(t, string(dump), "CREATE TABLE `comments`")
+		require.NotContains(t, string(dump), "migrations_lock")
+
+		// Only the files of registered migrations which ran are pruned.
+		left, err := ioutil.ReadDir(dir)
+		require.NoError(t, err)
+		require.Len(t, left, 2)
+		require.Equal(t, "create_comments_table.go", left[0].Name())
+		require.Equal(t, "helpers.go", left[1].Name())
+
+		// An empty database gets the dump, then the newer migrations.
+		_, _, err = schema.Wipe(conn, true)
+		require.NoError(t, err)
+		// Other groups are not part of the dump.
+		_, _, err = app.Call("migrate", "--group=billing")
+		require.NoError(t, err)
+		require.False(t, conn.Migrator().HasTable("users"))
+		_, _, err = schema.Wipe(conn, true)
+		require.NoError(t, err)
+		_, _, err = app.Call("migrate", "--group=default")
+		require.NoError(t, err)
+		ran["create_comments_table"] = 2
+		require.Equal(t, ran, batches())
+		require.True(t, conn.Migrator().HasTable("comments"))
+		var count int64
+		require.NoError(t, conn.Table("users").Count(&count).Error)
+		require.EqualValues(t, 0, count)
+	}).Run()
+}
@@ -0,0 +1,103 @@
+package db
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"unicode"
+
+	mysqldriver "github.com/go-sql-driver/mysql"
+)
+
+const (
+	TLSDisable    = "disable"
+	TLSRequire    = "require"
+	TLSVerifyCA   = "verify-ca"
+	TLSVerifyFull = "verify-full"
+)
+
+func (conf TLSConfig) serverName(host string) string {
+	if conf.ServerName != "" {
+		return conf.ServerName
+	}
+	return host
+}
+
+// Build the client tls config matching the mode, nil when tls is disabled.
+func (conf TLSConfig) config(host string) (*tls.Config, error) {
+	if conf.Mode == "" || conf.Mode == TLSDisable {
+		return nil, nil
+	}
+	config := &tls.Config{ServerName: conf.serverName(host)}
+	if conf.Cert != "" || conf.Key != "" {
+		cert, err := tls.LoadX509KeyPair(conf.Cert, conf.Key)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	if conf.CA != "" {
+		pem, err := ioutil.ReadFile(conf.CA)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in %s", conf.CA)
+		}
+	}
+	switch conf.Mode {
+	case TLSRequire:
+		config.InsecureSkipVerify = true
+	case TLSVerifyCA:
+		// Check the chain only, the host name may differ from the certificate.
+		config.InsecureSkipVerify = true
+		config.VerifyPeerCertificate = verifyChain(config.RootCAs)
+	}
+	return config, nil
+}
+
+func verifyChain(roots *x509.CertPool) func([][]byte, [][]*x509.Certificate) error {
+	return func(raw [][]byte, _ [][]*x509.Certificate) error {
+		if len(raw) == 0 {
+			return errors.New("server presented no certificate")
+		}
+		certs := make([]*x509.Certificate, len(raw))
+		for i, der := range raw {
+			cert, err := x509.ParseCertificate(der)
+			if err != nil {
+				return err
+			}
+			certs[i] = cert
+		}
+		intermediates := x509.NewCertPool()
+		for _, cert := range certs[1:] {
+			intermediates.AddCert(cert)
+		}
+		_, err := certs[0].Verify(x509.VerifyOptions{Roots: roots, Intermediates: intermediates})
+		return err
+	}
+}
+
+// Register the tls config with the mysql driver and get the name to put in
+// the dsn, empty when tls is disabled.
+func (conf TLSConfig) registerMysql(host string, port int) (string, error) {
+	config, err := conf.config(host)
+	if err != nil || config == nil {
+		return "", err
+	}
+	// The mysql driver reads the name verbatim from the dsn, keep it url safe.
+	name := strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToLower(r)
+		}
+		return '_'
+	}, fmt.Sprintf("service_%s_%d", host, port))
+	if err = mysqldriver.RegisterTLSConfig(name, config); err != nil {
+		return "", err
+	}
+	return name, nil
+}
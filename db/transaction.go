@@ -0,0 +1,139 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	mysqldriver "github.com/go-sql-driver/mysql"
+	"gorm.io/gorm"
+)
+
+// TxOptions of a transaction started through Manager.Transaction.
+type TxOptions struct {
+	Isolation sql.IsolationLevel
+	ReadOnly  bool
+	// Number of times the transaction is run again after a deadlock or
+	// serialization failure.
+	Retries int
+	// Wait before the first retry, doubled on every further retry.
+	Backoff time.Duration
+}
+
+type txKey struct {
+	connection string
+}
+
+type currentTxKey struct{}
+
+// State of a running transaction, shared by its nested transactions.
+type txState struct {
+	tx          *gorm.DB
+	mu          sync.Mutex
+	afterCommit []func()
+}
+
+// Run fn in a transaction of the named connection, the default connection
+// when name is empty. It commits when fn returns nil and rolls back otherwise.
+//
+// Calling Transaction again with tx.Statement.Context runs a nested
+// transaction on a savepoint, which is rolled back alone when the nested fn
+// fails. Retries only apply to the outermost transaction.
+func (m *Manager) Transaction(ctx context.Context, name string, fn func(tx *gorm.DB) error, opts ...*TxOptions) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	name = m.connectionName([]string{name})
+	if state, ok := ctx.Value(txKey{name}).(*txState); ok {
+		return state.nested(ctx, fn)
+	}
+	options := new(TxOptions)
+	if len(opts) > 0 && opts[0] != nil {
+		options = opts[0]
+	}
+	conn, err := m.ConnectionE(name)
+	if err != nil {
+		return err
+	}
+	backoff := options.Backoff
+	for attempt := 0; ; attempt++ {
+		state := new(txState)
+		err = conn.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			state.tx = tx
+			txCtx := context.WithValue(context.WithValue(ctx, txKey{name}, state), currentTxKey{}, state)
+			return fn(tx.WithContext(txCtx))
+		}, &sql.TxOptions{Isolation: options.Isolation, ReadOnly: options.ReadOnly})
+		if err == nil {
+			state.committed()
+			return nil
+		}
+		if attempt >= options.Retries || !retryable(err) {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+func (state *txState) nested(ctx context.Context, fn func(tx *gorm.DB) error) error {
+	state.mu.Lock()
+	registered := len(state.afterCommit)
+	state.mu.Unlock()
+	err := state.tx.WithContext(ctx).Transaction(fn)
+	if err != nil {
+		// Drop the callbacks registered by the rolled back savepoint.
+		state.mu.Lock()
+		state.afterCommit = state.afterCommit[:registered]
+		state.mu.Unlock()
+	}
+	return err
+}
+
+func (state *txState) committed() {
+	for _, fn := range state.afterCommit {
+		fn()
+	}
+}
+
+// Run fn once the transaction ctx belongs to commits, it is dropped when the
+// transaction rolls back. Outside of a transaction fn runs right away.
+func AfterCommit(ctx context.Context, fn func()) {
+	if ctx != nil {
+		if state, ok := ctx.Value(currentTxKey{}).(*txState); ok {
+			state.mu.Lock()
+			state.afterCommit = append(state.afterCommit, fn)
+			state.mu.Unlock()
+			return
+		}
+	}
+	fn()
+}
+
+// Report whether err is a deadlock or serialization failure, after which the
+// whole transaction may succeed when run again.
+func retryable(err error) bool {
+	var mysqlErr *mysqldriver.MySQLError
+	if errors.As(err, &mysqlErr) {
+		// ER_LOCK_DEADLOCK and ER_LOCK_WAIT_TIMEOUT.
+		return mysqlErr.Number == 1213 || mysqlErr.Number == 1205
+	}
+	var pgErr interface{ SQLState() string }
+	if errors.As(err, &pgErr) {
+		// serialization_failure and deadlock_detected.
+		return pgErr.SQLState() == "40001" || pgErr.SQLState() == "40P01"
+	}
+	var mssqlErr interface{ SQLErrorNumber() int32 }
+	if errors.As(err, &mssqlErr) {
+		return mssqlErr.SQLErrorNumber() == 1205
+	}
+	// SQLITE_BUSY and SQLITE_LOCKED.
+	message := err.Error()
+	return strings.Contains(message, "database is locked") || strings.Contains(message, "database table is locked")
+}
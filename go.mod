@@ -6,6 +6,7 @@ require (
 	github.com/AlecAivazis/survey/v2 v2.2.8
 	github.com/Joker/hpp v1.0.0 // indirect
 	github.com/go-redis/redis/v8 v8.6.0
+	github.com/go-sql-driver/mysql v1.5.0
 	github.com/goava/di v1.9.0
 	github.com/golang-module/carbon v1.3.3
 	github.com/jinzhu/inflection v1.0.0
@@ -13,6 +14,8 @@ require (
 	github.com/kataras/iris/v12 v12.2.0-alpha2.0.20210219075829-bfbed2f84174
 	github.com/lestrrat-go/file-rotatelogs v2.4.0+incompatible
 	github.com/lestrrat-go/strftime v1.0.4 // indirect
+	github.com/mitchellh/mapstructure v1.4.1
+	github.com/prometheus/client_golang v1.9.0
 	github.com/smartystreets/goconvey v1.6.4 // indirect
 	github.com/stretchr/testify v1.7.0
 	github.com/urionz/cobra v1.1.8
@@ -26,5 +29,9 @@ require (
 	go.uber.org/multierr v1.6.0 // indirect
 	go.uber.org/zap v1.16.0
 	gorm.io/driver/mysql v1.0.4
+	gorm.io/driver/postgres v1.0.8
+	gorm.io/driver/sqlite v1.1.4
+	gorm.io/driver/sqlserver v1.0.6
 	gorm.io/gorm v1.20.12
+	gorm.io/plugin/dbresolver v1.1.0
 )
@@ -0,0 +1,53 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/urionz/cobra"
+	"github.com/urionz/cobra/show"
+	"github.com/urionz/color"
+	"github.com/urionz/goofy"
+)
+
+type CheckCommand struct {
+	timeout time.Duration
+}
+
+func (cmd *CheckCommand) Handle(app goofy.IApplication) *cobra.Command {
+	command := &cobra.Command{
+		Use:   "health:check",
+		Short: "检查服务依赖的健康状态",
+		RunE: func(c *cobra.Command, args []string) error {
+			var registry *Registry
+			if err := app.Resolve(&registry); err != nil {
+				return err
+			}
+			if cmd.timeout > 0 {
+				registry.SetTimeout(cmd.timeout)
+			}
+			report := registry.Check(context.Background())
+			rows := []string{"Check\tStatus\tDuration\tError"}
+			for _, result := range report.Checks {
+				rows = append(rows, fmt.Sprintf(
+					"%s\t%s\t%s\t%s", result.Name, result.Status, result.Duration.Round(time.Microsecond), result.Error,
+				))
+			}
+			if err := show.TabWriter(c.OutOrStdout(), rows).Flush(); err != nil {
+				return err
+			}
+			if !report.Healthy() {
+				err := errors.New("some health checks failed")
+				color.Errorln(err)
+				return err
+			}
+			return nil
+		},
+	}
+
+	command.PersistentFlags().DurationVar(&cmd.timeout, "timeout", 0, "单项检查的超时时间，默认为 5s")
+
+	return command
+}
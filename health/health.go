@@ -0,0 +1,147 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	StatusUp   = "up"
+	StatusDown = "down"
+)
+
+// Check the health of a dependency, nil when it is healthy.
+type CheckFunc func(ctx context.Context) error
+
+// Source of checks, the services provided to the container implementing it
+// are added to the registry by the health service provider. The checks are
+// asked for on every run, so they follow the current config.
+type Source interface {
+	HealthChecks() map[string]CheckFunc
+}
+
+// Result of a single check.
+type Result struct {
+	Name     string        `json:"name"`
+	Status   string        `json:"status"`
+	Duration time.Duration `json:"duration"`
+	Error    string        `json:"error,omitempty"`
+}
+
+// Report of a run of every check, up when all of them are.
+type Report struct {
+	Status string    `json:"status"`
+	Checks []*Result `json:"checks"`
+}
+
+func (report *Report) Healthy() bool {
+	return report.Status == StatusUp
+}
+
+type Registry struct {
+	mu      sync.RWMutex
+	checks  map[string]CheckFunc
+	sources []Source
+	timeout time.Duration
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		checks:  make(map[string]CheckFunc),
+		timeout: 5 * time.Second,
+	}
+}
+
+// Set how long a single check may take before it is reported down.
+func (r *Registry) SetTimeout(timeout time.Duration) *Registry {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.timeout = timeout
+	return r
+}
+
+// Register a check, replacing the one registered under the same name.
+func (r *Registry) Register(name string, check CheckFunc) *Registry {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.checks[name] = check
+	return r
+}
+
+// Add a source whose checks are run along the registered ones.
+func (r *Registry) AddSource(source Source) *Registry {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sources = append(r.sources, source)
+	return r
+}
+
+// Run every check concurrently and report their results ordered by name.
+func (r *Registry) Check(ctx context.Context) *Report {
+	r.mu.RLock()
+	checks := make(map[string]CheckFunc, len(r.checks))
+	for name, check := range r.checks {
+		checks[name] = check
+	}
+	for _, source := range r.sources {
+		for name, check := range source.HealthChecks() {
+			checks[name] = check
+		}
+	}
+	timeout := r.timeout
+	r.mu.RUnlock()
+
+	report := &Report{Status: StatusUp, Checks: make([]*Result, 0, len(checks))}
+	results := make(chan *Result, len(checks))
+	for name, check := range checks {
+		go func(name string, check CheckFunc) {
+			results <- run(ctx, name, check, timeout)
+		}(name, check)
+	}
+	for range checks {
+		result := <-results
+		if result.Status != StatusUp {
+			report.Status = StatusDown
+		}
+		report.Checks = append(report.Checks, result)
+	}
+	sort.Slice(report.Checks, func(i, j int) bool {
+		return report.Checks[i].Name < report.Checks[j].Name
+	})
+	return report
+}
+
+func run(ctx context.Context, name string, check CheckFunc, timeout time.Duration) (result *Result) {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	result = &Result{Name: name, Status: StatusUp}
+	begin := time.Now()
+	done := make(chan error, 1)
+	go func() {
+		defer func() {
+			if err := recover(); err != nil {
+				done <- fmt.Errorf("panic: %v", err)
+			}
+		}()
+		done <- check(ctx)
+	}()
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		// Checks ignoring the context are reported down once it expires.
+		err = ctx.Err()
+	}
+	result.Duration = time.Since(begin)
+	if err != nil {
+		result.Status = StatusDown
+		result.Error = err.Error()
+	}
+	return result
+}
@@ -0,0 +1,24 @@
+package health
+
+import (
+	"github.com/goava/di"
+	"github.com/urionz/goofy"
+)
+
+// Sources of checks provided by the other services, e.g. the db, redis and
+// cache managers.
+type registryDeps struct {
+	di.Inject
+	Sources []Source `optional:"true"`
+}
+
+func NewServiceProvider(app goofy.IApplication) error {
+	app.AddCommanders(new(CheckCommand))
+	return app.Provide(func(deps registryDeps) *Registry {
+		registry := NewRegistry()
+		for _, source := range deps.Sources {
+			registry.AddSource(source)
+		}
+		return registry
+	})
+}
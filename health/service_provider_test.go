@@ -0,0 +1,83 @@
+package health_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/goava/di"
+	"github.com/stretchr/testify/require"
+	"github.com/urionz/goofy"
+	"github.com/urionz/service/health"
+)
+
+type source map[string]health.CheckFunc
+
+func (s source) HealthChecks() map[string]health.CheckFunc {
+	return s
+}
+
+func TestNewServiceProvider(t *testing.T) {
+	app := goofy.New()
+	app.AddServices(health.NewServiceProvider, func(app goofy.IApplication) error {
+		if err := app.Provide(func() source {
+			return source{
+				"source.up": func(context.Context) error {
+					return nil
+				},
+			}
+		}, di.As(new(health.Source))); err != nil {
+			return err
+		}
+		return app.Provide(func() *source {
+			return &source{
+				"other.up": func(context.Context) error {
+					return nil
+				},
+			}
+		}, di.As(new(health.Source)))
+	}, func(registry *health.Registry) {
+		report := registry.Check(context.Background())
+		require.True(t, report.Healthy())
+		require.Len(t, report.Checks, 2)
+		require.Equal(t, "other.up", report.Checks[0].Name)
+		require.Equal(t, "source.up", report.Checks[1].Name)
+
+		registry.Register("down", func(context.Context) error {
+			return errors.New("refused")
+		})
+		_, output, err := app.Call("health:check")
+		require.Error(t, err)
+		require.Regexp(t, `down\s+\|down\s+\|\S+\s+\|refused`, output)
+		require.Regexp(t, `source.up\s+\|up`, output)
+	}).Run()
+}
+
+func TestRegistryCheck(t *testing.T) {
+	registry := health.NewRegistry().SetTimeout(50 * time.Millisecond)
+	registry.Register("slow", func(context.Context) error {
+		time.Sleep(time.Second)
+		return nil
+	})
+	registry.Register("panic", func(context.Context) error {
+		panic("boom")
+	})
+	registry.Register("up", func(ctx context.Context) error {
+		_, ok := ctx.Deadline()
+		require.True(t, ok)
+		return nil
+	})
+
+	begin := time.Now()
+	report := registry.Check(context.Background())
+	require.Less(t, int64(time.Since(begin)), int64(time.Second))
+	require.False(t, report.Healthy())
+	require.Equal(t, health.StatusDown, report.Status)
+	require.Len(t, report.Checks, 3)
+	require.Equal(t, "panic", report.Checks[0].Name)
+	require.Equal(t, "panic: boom", report.Checks[0].Error)
+	require.Equal(t, "slow", report.Checks[1].Name)
+	require.Equal(t, context.DeadlineExceeded.Error(), report.Checks[1].Error)
+	require.Equal(t, health.StatusUp, report.Checks[2].Status)
+}
@@ -0,0 +1,7 @@
+package log
+
+// Config of the logger section.
+type Config struct {
+	Level string `config:"level" default:"debug" validate:"oneof=debug info warn error panic fatal"`
+	Color bool   `config:"color" default:"true"`
+}
@@ -3,6 +3,7 @@ package log
 import (
 	"io"
 	"os"
+	"sync"
 	"time"
 
 	rotatelogs "github.com/lestrrat-go/file-rotatelogs"
@@ -22,10 +23,25 @@ const (
 
 type Logger struct {
 	*zap.Logger
-	conf config.IConfig
+	conf     config.IConfig
+	level    zap.AtomicLevel
+	channels sync.Map
 }
 
-var log *Logger
+var log = &Logger{
+	Logger: zap.NewNop(),
+}
+
+// Bind logger to the package level facade functions, which discard
+// everything until a logger is bound.
+func SetDefault(logger *Logger) {
+	log = logger
+}
+
+// Get the logger behind the package level facade functions.
+func Default() *Logger {
+	return log
+}
 
 func Debug(args ...interface{}) {
 	log.Logger.WithOptions(zap.AddCallerSkip(1)).Sugar().Debug(args...)
@@ -76,13 +92,52 @@ func Fatalf(format string, args ...interface{}) {
 }
 
 func NewLogger(conf config.IConfig) *Logger {
-	log = new(Logger)
-	log.conf = conf
-	log.Logger = log.newZapLogger(log.parseLogLevel(conf.String("logger.level", "debug")))
-	return log
+	logger := new(Logger)
+	logger.conf = conf
+	logger.level = zap.NewAtomicLevelAt(logger.parseLogLevel(logger.config(conf).Level))
+	logger.Logger = logger.newZapLogger(logger.level)
+	conf.OnChange("logger.level", logger.onLevelChange)
+	return logger
 }
 
-func (logger *Logger) newZapLogger(level zapcore.Level) *zap.Logger {
+// Apply a reloaded logger.level without rebuilding the logger.
+func (logger *Logger) onLevelChange(_, conf config.IConfig) {
+	logger.level.SetLevel(logger.parseLogLevel(logger.config(conf).Level))
+}
+
+// Decode the logger section, the provider has already validated it.
+func (*Logger) config(conf config.IConfig) *Config {
+	c := new(Config)
+	conf.Decode("logger", c)
+	return c
+}
+
+// Get the logger of a channel, writing to its own rotated ./<name>.log file so
+// its entries are kept apart from the application logs. A logger not built by
+// NewLogger has no files, its channels write to the logger itself.
+func (logger *Logger) Channel(name string) *Logger {
+	if logger.conf == nil {
+		return logger
+	}
+	if channel, ok := logger.channels.Load(name); ok {
+		return channel.(*Logger)
+	}
+	conf := logger.encoderConfig(false)
+	conf.EncodeLevel = zapcore.CapitalLevelEncoder
+	core := zapcore.NewCore(
+		zapcore.NewConsoleEncoder(conf),
+		zapcore.AddSync(logger.getLevelWriter("./"+name)),
+		logger.level,
+	)
+	channel, _ := logger.channels.LoadOrStore(name, &Logger{
+		Logger: zap.New(core, zap.AddCaller()).Named(name),
+		conf:   logger.conf,
+		level:  logger.level,
+	})
+	return channel.(*Logger)
+}
+
+func (logger *Logger) encoderConfig(color bool) zapcore.EncoderConfig {
 	conf := zapcore.EncoderConfig{
 		TimeKey:    "time",
 		LevelKey:   "level",
@@ -96,17 +151,22 @@ func (logger *Logger) newZapLogger(level zapcore.Level) *zap.Logger {
 		EncodeDuration: zapcore.SecondsDurationEncoder,
 		EncodeCaller:   zapcore.FullCallerEncoder,
 	}
-	if logger.conf.Bool("logger.color", true) {
+	if color {
 		conf.EncodeLevel = zapcore.CapitalColorLevelEncoder
 	}
+	return conf
+}
+
+func (logger *Logger) newZapLogger(level zap.AtomicLevel) *zap.Logger {
+	conf := logger.encoderConfig(logger.config(logger.conf).Color)
 	encoder := zapcore.NewConsoleEncoder(conf)
 
 	infoLevel := zap.LevelEnablerFunc(func(lvl zapcore.Level) bool {
-		return lvl < zapcore.WarnLevel && lvl >= level
+		return lvl < zapcore.WarnLevel && level.Enabled(lvl)
 	})
 
 	warnLevel := zap.LevelEnablerFunc(func(lvl zapcore.Level) bool {
-		return lvl >= zapcore.WarnLevel && lvl >= level
+		return lvl >= zapcore.WarnLevel && level.Enabled(lvl)
 	})
 
 	infoLevelWriter := logger.getLevelWriter("./info")
@@ -6,7 +6,12 @@ import (
 )
 
 func NewServiceProvider(app goofy.IApplication, conf config.IConfig) error {
+	if err := conf.RegisterSection("logger", new(Config)); err != nil {
+		return err
+	}
 	return app.Provide(func() *Logger {
-		return NewLogger(conf)
+		logger := NewLogger(conf)
+		SetDefault(logger)
+		return logger
 	})
 }
@@ -7,6 +7,7 @@ import (
 	"github.com/urionz/goofy"
 	"github.com/urionz/service/config"
 	"github.com/urionz/service/log"
+	"go.uber.org/zap"
 )
 
 func TestNewServiceProvider(t *testing.T) {
@@ -36,3 +37,16 @@ func TestNewServiceProvider(t *testing.T) {
 		}).Run()
 	})
 }
+
+func TestChannel(t *testing.T) {
+	goofy.New().AddServices(config.NewServiceProvider, log.NewServiceProvider, func(logger *log.Logger) {
+		channel := logger.Channel("channel")
+		require.Same(t, channel, logger.Channel("channel"))
+		require.NotSame(t, logger, channel)
+		channel.Info("channel")
+		require.FileExists(t, "channel.log")
+	}).Run()
+
+	logger := &log.Logger{Logger: zap.NewNop()}
+	require.Same(t, logger, logger.Channel("channel"))
+}
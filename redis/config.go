@@ -0,0 +1,11 @@
+package redis
+
+// Config of the database.redis section, keyed by connection name.
+type Config map[string]*ConnectionConfig
+
+// ConnectionConfig of a single database.redis.<name> entry.
+type ConnectionConfig struct {
+	Address  string `config:"address" default:"localhost:6379" validate:"required"`
+	Password string `config:"password"`
+	DB       int    `config:"db" default:"0" validate:"min=0"`
+}
@@ -40,6 +40,11 @@ func (conn *Connection) GetName() string {
 	return conn.name
 }
 
+// Ping the server, failing once ctx expires.
+func (conn *Connection) Ping(ctx context.Context) error {
+	return conn.client.Ping(ctx).Err()
+}
+
 func (conn *Connection) Get(key string) string {
 	return conn.client.Get(context.Background(), key).Val()
 }
@@ -8,6 +8,7 @@ import (
 	"github.com/go-redis/redis/v8"
 	"github.com/urionz/goofy"
 	"github.com/urionz/service/config"
+	"github.com/urionz/service/health"
 )
 
 type Manager struct {
@@ -19,6 +20,8 @@ type Manager struct {
 
 var _ Factory = (*Manager)(nil)
 
+var _ health.Source = (*Manager)(nil)
+
 func NewRedisManager(app goofy.IApplication, conf config.IConfig) *Manager {
 	manager := &Manager{
 		app:  app,
@@ -36,7 +39,11 @@ func (m *Manager) Connection(name ...string) (*Connection, error) {
 	if conn, ok := m.connections.Load(name[0]); ok {
 		return conn.(*Connection), nil
 	}
-	if conn, err = m.configure(m.conf.Object(fmt.Sprintf("database.redis.%s", name[0])), name[0]); err != nil {
+	conf := new(ConnectionConfig)
+	if err = m.conf.Decode(fmt.Sprintf("database.redis.%s", name[0]), conf); err != nil {
+		return nil, err
+	}
+	if conn, err = m.configure(conf, name[0]); err != nil {
 		return nil, err
 	}
 	m.connections.Store(name[0], conn)
@@ -44,13 +51,33 @@ func (m *Manager) Connection(name ...string) (*Connection, error) {
 	return conn, nil
 }
 
-func (m *Manager) configure(conf config.IConfig, name string) (*Connection, error) {
+func (m *Manager) configure(conf *ConnectionConfig, name string) (*Connection, error) {
 	conn := NewConnection(redis.NewClient(
 		&redis.Options{
-			Addr:     conf.String("address", "localhost:6379"),
-			Password: conf.String("password", ""),
-			DB:       conf.Int("db", 0),
+			Addr:     conf.Address,
+			Password: conf.Password,
+			DB:       conf.DB,
 		},
 	)).SetName(name)
 	return conn, conn.client.Ping(context.Background()).Err()
 }
+
+// Get a check pinging each configured connection, named redis.<connection>.
+func (m *Manager) HealthChecks() map[string]health.CheckFunc {
+	var conns map[string]interface{}
+	if err := m.conf.Decode("database.redis", &conns); err != nil {
+		return nil
+	}
+	checks := make(map[string]health.CheckFunc, len(conns))
+	for name := range conns {
+		name := name
+		checks["redis."+name] = func(ctx context.Context) error {
+			conn, err := m.Connection(name)
+			if err != nil {
+				return err
+			}
+			return conn.Ping(ctx)
+		}
+	}
+	return checks
+}
@@ -1,13 +1,18 @@
 package redis
 
 import (
+	"github.com/goava/di"
 	"github.com/urionz/goofy"
 	"github.com/urionz/service/config"
+	"github.com/urionz/service/health"
 )
 
 func NewServiceProvider(app goofy.IApplication, conf config.IConfig) error {
+	if err := conf.RegisterSection("database.redis", new(Config)); err != nil {
+		return err
+	}
 	app.Provide(func() (*Manager, error) {
 		return NewRedisManager(app, conf), nil
-	})
+	}, di.As(new(health.Source)))
 	return nil
 }
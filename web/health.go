@@ -0,0 +1,37 @@
+package web
+
+import (
+	"net/http"
+
+	"github.com/kataras/iris/v12"
+	"github.com/urionz/service/health"
+)
+
+// Mount the liveness and readiness endpoints of registry on router.
+func MountHealth(router iris.Party, registry *health.Registry) {
+	router.Get("/health", HealthHandler())
+	router.Get("/ready", ReadyHandler(registry))
+}
+
+// Liveness endpoint, answering as long as the server is able to serve.
+func HealthHandler() iris.Handler {
+	return func(ctx iris.Context) {
+		ctx.JSON(iris.Map{"status": health.StatusUp})
+	}
+}
+
+// Readiness endpoint, running every check of registry and answering 503 when
+// one of them is down. The errors are left out, driver errors may name hosts
+// or users, health:check shows them.
+func ReadyHandler(registry *health.Registry) iris.Handler {
+	return func(ctx iris.Context) {
+		report := registry.Check(ctx.Request().Context())
+		if !report.Healthy() {
+			ctx.StatusCode(http.StatusServiceUnavailable)
+		}
+		for _, result := range report.Checks {
+			result.Error = ""
+		}
+		ctx.JSON(report)
+	}
+}
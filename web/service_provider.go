@@ -7,6 +7,7 @@ import (
 	"github.com/urionz/cobra"
 	"github.com/urionz/goofy"
 	"github.com/urionz/service/config"
+	"github.com/urionz/service/health"
 )
 
 func NewServiceProvider(app goofy.IApplication) error {
@@ -37,6 +38,10 @@ func (cmd *engine) Handle(app goofy.IApplication) *cobra.Command {
 				panic(err)
 			}
 			cmd.fillArgs(conf)
+			var registry *health.Registry
+			if err := app.Resolve(&registry); err == nil {
+				MountHealth(cmd.Application, registry)
+			}
 			addr := fmt.Sprintf("0.0.0.0:%d", cmd.port)
 			if cmd.debug {
 				cmd.Logger().SetLevel("debug")
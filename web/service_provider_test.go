@@ -1,11 +1,18 @@
 package web_test
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 
+	"github.com/kataras/iris/v12"
 	"github.com/stretchr/testify/require"
 	"github.com/urionz/goofy"
 	"github.com/urionz/service/config"
+	"github.com/urionz/service/health"
 	"github.com/urionz/service/web"
 )
 
@@ -14,3 +21,34 @@ func TestNewServiceProvider(t *testing.T) {
 		goofy.Default.AddServices(config.NewServiceProvider, web.NewServiceProvider)
 	})
 }
+
+func TestHealthHandlers(t *testing.T) {
+	registry := health.NewRegistry()
+	app := iris.New()
+	web.MountHealth(app, registry)
+	require.NoError(t, app.Build())
+
+	get := func(path string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		app.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+		return recorder
+	}
+
+	response := get("/health")
+	require.Equal(t, http.StatusOK, response.Code)
+	require.JSONEq(t, `{"status":"up"}`, response.Body.String())
+
+	require.Equal(t, http.StatusOK, get("/ready").Code)
+
+	registry.Register("db", func(context.Context) error {
+		return errors.New("refused")
+	})
+	response = get("/ready")
+	require.Equal(t, http.StatusServiceUnavailable, response.Code)
+	report := new(health.Report)
+	require.NoError(t, json.Unmarshal(response.Body.Bytes(), report))
+	require.Equal(t, health.StatusDown, report.Status)
+	require.Equal(t, health.StatusDown, report.Checks[0].Status)
+	require.Empty(t, report.Checks[0].Error)
+	require.NotContains(t, response.Body.String(), "refused")
+}